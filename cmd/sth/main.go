@@ -1,21 +1,105 @@
 package main
 
+//go:generate sh -c "go run . schema packages > ../../schemas/packages.schema.json"
+//go:generate sh -c "go run . schema recipe > ../../schemas/recipe.schema.json"
+
 import (
+	"bufio"
 	"context"
 	"fmt"
 	"log"
 	"os"
+	"slices"
+	"strings"
 
 	"github.com/aottr/sth/internal"
 	"github.com/aottr/sth/internal/brew"
-	"github.com/aottr/sth/internal/flatpak"
+	"github.com/aottr/sth/internal/diff"
 	"github.com/aottr/sth/internal/install"
-	"github.com/aottr/sth/internal/native"
+	"github.com/aottr/sth/internal/installer"
 	"github.com/aottr/sth/internal/recipes"
 	"github.com/aottr/sth/internal/sthpkgs"
+	"github.com/aottr/sth/internal/utils"
 	"github.com/urfave/cli/v3"
 )
 
+// addTypes maps `sth add -t` values to packages.yml sections
+var addTypes = map[string]internal.PackageType{
+	"apt":     internal.PackageTypeApt,
+	"flatpak": internal.PackageTypeFlatpak,
+	"snap":    internal.PackageTypeSnap,
+	"recipe":  internal.PackageTypeRecipe,
+	"brew":    internal.PackageTypeBrew,
+}
+
+// packageTypeFlag is the -t flag shared by add and remove
+func packageTypeFlag() *cli.StringFlag {
+	return &cli.StringFlag{
+		Name:    "type",
+		Usage:   "[apt|flatpak|snap|brew|recipe]",
+		Value:   "apt",
+		Aliases: []string{"t"},
+		Validator: func(t string) error {
+			if _, ok := addTypes[t]; !ok {
+				return fmt.Errorf("invalid package type: %s", t)
+			}
+			return nil
+		},
+	}
+}
+
+// loadPackages loads a packages config with the profiles selected by
+// --profile or STH_PROFILE
+func loadPackages(cmd *cli.Command, file string) (*internal.Packages, error) {
+	return internal.LoadPackages(file, cmd.StringSlice("profile")...)
+}
+
+// planFromFile loads the packages config and plans every installer. With
+// quiet set, progress output of the installers goes to stderr so stdout only
+// carries the plan.
+func planFromFile(ctx context.Context, cmd *cli.Command, quiet bool) (*install.Plan, error) {
+	pkgs, err := loadPackages(cmd, cmd.String("file"))
+	if err != nil {
+		return nil, err
+	}
+	if quiet {
+		stdout := os.Stdout
+		os.Stdout = os.Stderr
+		defer func() { os.Stdout = stdout }()
+	}
+	return install.MakePlan(ctx, pkgs, install.Installers())
+}
+
+func printPlan(plan *install.Plan, asJSON, verbose bool) error {
+	if asJSON {
+		return plan.WriteJSON(os.Stdout)
+	}
+	plan.Print(os.Stdout, verbose)
+	fmt.Printf("📋 %d changes pending\n", plan.Pending())
+	return nil
+}
+
+// isTerminal reports whether f is a terminal and colors are not disabled
+// through NO_COLOR
+func isTerminal(f *os.File) bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	st, err := f.Stat()
+	return err == nil && st.Mode()&os.ModeCharDevice != 0
+}
+
+// confirm asks a yes/no question on the terminal, defaulting to no
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
+
 func main() {
 
 	cmd := &cli.Command{
@@ -28,67 +112,308 @@ func main() {
 				Value:   "packages.yml",
 				Aliases: []string{"f"},
 			},
+			&cli.StringSliceFlag{
+				Name:    "profile",
+				Usage:   "profiles of packages.yml to merge into the base config",
+				Aliases: []string{"p"},
+				Sources: cli.EnvVars("STH_PROFILE"),
+			},
 		},
 		Commands: []*cli.Command{
 			{
 				Name:    "install",
 				Aliases: []string{"i"},
 				Usage:   "Install packages and recipes from YAML",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "dry-run",
+						Usage: "show the plan without executing it",
+					},
+					&cli.BoolFlag{
+						Name:  "json",
+						Usage: "print the plan as JSON (implies --dry-run)",
+					},
+				},
 				Action: func(ctx context.Context, cmd *cli.Command) error {
+					dryRun := cmd.Bool("dry-run") || cmd.Bool("json")
+					ctx = utils.WithExecOptions(ctx, utils.ExecOptions{DryRun: dryRun})
 
-					pkgs, err := internal.LoadPackages(cmd.String("file"))
+					plan, err := planFromFile(ctx, cmd, cmd.Bool("json"))
 					if err != nil {
-						log.Fatalf("failed to load packages: %v", err)
+						log.Fatalf("failed to plan: %v", err)
+					}
+					if dryRun {
+						return printPlan(plan, cmd.Bool("json"), true)
+					}
+					plan.Print(os.Stdout, false)
+					if plan.Pending() == 0 {
+						fmt.Println("✅ Nothing to do, everything is up to date")
+						return nil
+					}
+					if err := install.Apply(ctx, plan); err != nil {
+						return err
 					}
 
-					// Install apt, flatpak packages
-					// driver, err := native.GetDriverForRelease(pkgs.Platform.Family, pkgs)
-					// if err != nil {
-					// 	log.Fatalf("failed to get driver for distro: %v", err)
-					// }
-					// if err := driver.InstallAll(); err != nil {
-					// 	log.Fatalf("apt install failed: %v", err)
-					// }
-					// if err := flatpak.InstallFlatpak(pkgs.Flatpak); err != nil {
-					// 	log.Fatalf("flatpak install failed: %v", err)
-					// }
-
-					// if err := brew.InstallWithBundle(pkgs.Brew, brew.InstallOptions{
-					// 	Prefetch:     false,
-					// 	NoAutoUpdate: true,
-					// }); err != nil {
-					// 	log.Fatalf("brew install failed: %v", err)
-					// }
+					fmt.Println("🎉 All installs and recipes completed successfully!")
+					return nil
+				},
+			},
+			{
+				Name:  "sync",
+				Usage: "Install packages.yml and report or prune what it does not declare",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "prune",
+						Usage: "remove packages that are not declared",
+					},
+					&cli.BoolFlag{
+						Name:    "yes",
+						Usage:   "do not ask for confirmation",
+						Aliases: []string{"y"},
+					},
+					&cli.BoolFlag{
+						Name:  "dry-run",
+						Usage: "show the plan and drift without executing anything",
+					},
+				},
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					dryRun := cmd.Bool("dry-run")
+					ctx = utils.WithExecOptions(ctx, utils.ExecOptions{DryRun: dryRun})
 
-					install.InstallAll(install.Spec{
-						BrewFormulas: pkgs.Brew,
-						Flatpaks:     pkgs.Flatpak,
-					})
+					pkgs, err := loadPackages(cmd, cmd.String("file"))
+					if err != nil {
+						log.Fatalf("failed to load packages: %v", err)
+					}
+					installers := install.Installers()
+					plan, err := install.MakePlan(ctx, pkgs, installers)
+					if err != nil {
+						log.Fatalf("failed to plan: %v", err)
+					}
+					plan.Print(os.Stdout, false)
+					if err := install.Apply(ctx, plan); err != nil {
+						return err
+					}
 
-					// Run remote recipes
-					for _, recipeName := range pkgs.Recipes {
-						rr, err := recipes.FindRecipe(recipeName)
+					drift, err := install.Drift(ctx, pkgs, installers)
+					if err != nil {
+						log.Fatalf("failed to compute drift: %v", err)
+					}
+					if drift.Pending() == 0 {
+						fmt.Println("✅ Machine matches packages.yml")
+						return nil
+					}
+					fmt.Println("\n🔍 Installed but not declared in packages.yml:")
+					drift.Print(os.Stdout, true)
+					if !cmd.Bool("prune") {
+						fmt.Println("Run `sth sync --prune` to remove them, or add them to packages.yml")
+						return nil
+					}
+					if dryRun {
+						return nil
+					}
+					if !cmd.Bool("yes") && !confirm(fmt.Sprintf("Remove %d packages?", drift.Pending())) {
+						fmt.Println("Aborted")
+						return nil
+					}
+					return install.Apply(ctx, drift)
+				},
+			},
+			{
+				Name:  "status",
+				Usage: "Check whether this machine has everything in packages.yml; exits 1 if not",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "json",
+						Usage: "print the report as JSON",
+					},
+					&cli.BoolFlag{
+						Name:    "quiet",
+						Usage:   "print nothing, only set the exit code",
+						Aliases: []string{"q"},
+					},
+				},
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					pkgs, err := loadPackages(cmd, cmd.String("file"))
+					if err != nil {
+						log.Fatalf("failed to load packages: %v", err)
+					}
+					report, err := install.Status(ctx, pkgs, install.Installers())
+					if err != nil {
+						log.Fatalf("failed to check status: %v", err)
+					}
+					switch {
+					case cmd.Bool("quiet"):
+					case cmd.Bool("json"):
+						if err := report.WriteJSON(os.Stdout); err != nil {
+							return err
+						}
+					default:
+						report.Print(os.Stdout)
+					}
+					if !report.Compliant() {
+						if cmd.Bool("quiet") || cmd.Bool("json") {
+							os.Exit(1)
+						}
+						return fmt.Errorf("❌ %d of %d packages are not installed as declared", report.Failing(), len(report.Entries))
+					}
+					if !cmd.Bool("quiet") && !cmd.Bool("json") {
+						fmt.Println("✅ All declared packages are installed")
+					}
+					return nil
+				},
+			},
+			{
+				Name:  "validate",
+				Usage: "Check packages.yml for unknown fields and mistakes decoding cannot catch",
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					file := cmd.String("file")
+					pkgs, err := loadPackages(cmd, file)
+					if err != nil {
+						// strict decoding reports every unknown field, one per line
+						for _, line := range strings.Split(err.Error(), "\n") {
+							fmt.Printf("  ❌ %s\n", line)
+						}
+						return fmt.Errorf("❌ %s is invalid", file)
+					}
+					problems := pkgs.Validate()
+					for _, p := range problems {
+						fmt.Printf("  ❌ %v\n", p)
+					}
+					if len(problems) > 0 {
+						return fmt.Errorf("❌ %s has %d problems", file, len(problems))
+					}
+					fmt.Printf("✅ %s is valid\n", file)
+					return nil
+				},
+			},
+			{
+				Name:  "schema",
+				Usage: "Print the JSON Schema of packages.yml or recipe.yml",
+				Arguments: []cli.Argument{
+					&cli.StringArg{
+						Name:  "kind",
+						Value: "packages",
+					},
+				},
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					var (
+						out []byte
+						err error
+					)
+					switch kind := cmd.StringArg("kind"); kind {
+					case "packages":
+						out, err = internal.PackagesSchema()
+					case "recipe":
+						out, err = sthpkgs.RecipeSchema()
+					default:
+						return fmt.Errorf("unknown schema %q, use packages or recipe", kind)
+					}
+					if err != nil {
+						return err
+					}
+					_, err = os.Stdout.Write(out)
+					return err
+				},
+			},
+			{
+				Name:  "diff",
+				Usage: "Compare two packages files, or a packages file with this machine",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "live",
+						Usage: "compare --file with what is installed",
+					},
+					&cli.StringFlag{
+						Name:  "format",
+						Usage: "[text|json|unified]",
+						Value: "text",
+						Validator: func(f string) error {
+							switch f {
+							case "text", "json", "unified":
+								return nil
+							default:
+								return fmt.Errorf("invalid format: %s", f)
+							}
+						},
+					},
+					&cli.BoolFlag{
+						Name:  "no-color",
+						Usage: "disable colored text output",
+					},
+				},
+				Arguments: []cli.Argument{
+					&cli.StringArgs{
+						Name: "file",
+						Min:  0,
+						Max:  2,
+					},
+				},
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					var result *diff.Result
+					files := cmd.StringArgs("file")
+					if cmd.Bool("live") {
+						pkgs, err := loadPackages(cmd, cmd.String("file"))
 						if err != nil {
-							return fmt.Errorf("recipe not found")
+							return fmt.Errorf("failed to load packages: %w", err)
 						}
-						recipe, err := recipes.FetchRecipe(*rr)
+						live, err := install.Live(ctx, pkgs, install.Installers())
 						if err != nil {
-							log.Fatalf("failed to fetch recipe '%s': %v", recipeName, err)
+							return fmt.Errorf("failed to query installed packages: %w", err)
 						}
-						// check if installed
-						if recipes.IsInstalled(recipeName) {
-							fmt.Println("🔄 Skipping already installed recipe package: ", recipeName)
-							continue
+						result = diff.Compare(cmd.String("file"), diff.FromPackages(pkgs), "live", live, diff.Satisfies)
+					} else {
+						if len(files) != 2 {
+							return fmt.Errorf("usage: sth diff a.yml b.yml, or sth diff --live")
 						}
-						if err := recipes.RunRecipe(recipeName, recipe); err != nil {
-							log.Fatalf("recipe '%s' failed: %v", recipeName, err)
+						a, err := loadPackages(cmd, files[0])
+						if err != nil {
+							return err
 						}
+						b, err := loadPackages(cmd, files[1])
+						if err != nil {
+							return err
+						}
+						result = diff.Compare(files[0], diff.FromPackages(a), files[1], diff.FromPackages(b), diff.Exact)
 					}
 
-					fmt.Println("🎉 All installs and recipes completed successfully!")
+					switch cmd.String("format") {
+					case "json":
+						return result.WriteJSON(os.Stdout)
+					case "unified":
+						result.WriteUnified(os.Stdout)
+					default:
+						if result.Empty() {
+							fmt.Println("✅ No differences")
+							return nil
+						}
+						result.Print(os.Stdout, !cmd.Bool("no-color") && isTerminal(os.Stdout))
+					}
 					return nil
 				},
 			},
+			{
+				Name:  "plan",
+				Usage: "Show what install would change without executing anything",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "json",
+						Usage: "print the plan as JSON",
+					},
+					&cli.BoolFlag{
+						Name:    "verbose",
+						Usage:   "list up-to-date entries and recipe actions",
+						Aliases: []string{"v"},
+					},
+				},
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					ctx = utils.WithExecOptions(ctx, utils.ExecOptions{DryRun: true})
+					plan, err := planFromFile(ctx, cmd, cmd.Bool("json"))
+					if err != nil {
+						log.Fatalf("failed to plan: %v", err)
+					}
+					return printPlan(plan, cmd.Bool("json"), cmd.Bool("verbose"))
+				},
+			},
 			{
 				Name:    "recipe",
 				Aliases: []string{"r", "res"},
@@ -134,20 +459,7 @@ func main() {
 				Name:    "add",
 				Aliases: []string{"a"},
 				Flags: []cli.Flag{
-					&cli.StringFlag{
-						Name:    "type",
-						Usage:   "[apt|flatpak|recipe]",
-						Value:   "apt",
-						Aliases: []string{"t"},
-						Validator: func(t string) error {
-							switch t {
-							case "apt", "flatpak", "recipe":
-								return nil
-							default:
-								return fmt.Errorf("invalid package type: %s", t)
-							}
-						},
-					},
+					packageTypeFlag(),
 				},
 				Arguments: []cli.Argument{
 					&cli.StringArgs{
@@ -158,7 +470,7 @@ func main() {
 				},
 				Usage: "Add a package to the list",
 				Action: func(ctx context.Context, cmd *cli.Command) error {
-					pkgConfig, err := internal.LoadPackages(cmd.String("file"))
+					pkgConfig, err := loadPackages(cmd, cmd.String("file"))
 					if err != nil {
 						log.Fatalf("failed to load packages: %v", err)
 					}
@@ -169,49 +481,94 @@ func main() {
 						return fmt.Errorf("no package specified")
 					}
 
-					switch packageType {
-					case "apt":
-						if err := pkgConfig.Add(internal.PackageTypeApt, names); err != nil {
-							return err
-						}
-						driver, err := native.GetDriverForRelease(pkgConfig.Platform.Family, nil)
-						if err != nil {
-							log.Fatalf("failed to get driver for distro: %v", err)
+					pkgType := addTypes[packageType]
+					if err := pkgConfig.Add(pkgType, names); err != nil {
+						return err
+					}
+					inst, ok := install.InstallerFor(string(pkgType))
+					if !ok {
+						log.Fatalf("no %s installer available on this system", packageType)
+					}
+					plan, err := install.MakePlan(ctx, pkgConfig, []installer.Installer{inst})
+					if err != nil {
+						log.Fatalf("failed to plan: %v", err)
+					}
+					plan = plan.Filter(names)
+					plan.Print(os.Stdout, false)
+					return install.Apply(ctx, plan)
+				},
+			},
+			{
+				Name:    "remove",
+				Aliases: []string{"rm"},
+				Flags: []cli.Flag{
+					packageTypeFlag(),
+					&cli.BoolFlag{
+						Name:  "purge",
+						Usage: "also uninstall the packages, and what depends on them",
+					},
+					&cli.BoolFlag{
+						Name:    "yes",
+						Usage:   "do not ask for confirmation",
+						Aliases: []string{"y"},
+					},
+				},
+				Arguments: []cli.Argument{
+					&cli.StringArgs{
+						Name: "package",
+						Min:  0,
+						Max:  20,
+					},
+				},
+				Usage: "Remove a package from the list",
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					pkgConfig, err := loadPackages(cmd, cmd.String("file"))
+					if err != nil {
+						log.Fatalf("failed to load packages: %v", err)
+					}
+
+					packageType := cmd.String("type")
+					names := cmd.StringArgs("package")
+					if len(names) == 0 {
+						return fmt.Errorf("no package specified")
+					}
+					pkgType := addTypes[packageType]
+
+					// plan and apply the uninstall first, the specs come from the
+					// entries being removed and a failed uninstall keeps them
+					if cmd.Bool("purge") {
+						inst, ok := install.InstallerFor(string(pkgType))
+						if !ok {
+							log.Fatalf("no %s installer available on this system", packageType)
 						}
-						err = driver.Install(names)
+						plan, err := install.RemovalPlan(ctx, pkgConfig, inst, names)
 						if err != nil {
-							return err
-						}
-					case "flatpak":
-						if err := pkgConfig.Add(internal.PackageTypeFlatpak, names); err != nil {
-							return err
-						}
-						if err := flatpak.InstallFlatpak(pkgConfig.Flatpak); err != nil {
-							log.Fatalf("flatpak install failed: %v", err)
-						}
-					case "recipe":
-						if err := pkgConfig.Add(internal.PackageTypeRecipe, names); err != nil {
-							return err
+							log.Fatalf("failed to plan: %v", err)
 						}
-						for _, recipeName := range names {
-							rr, err := recipes.FindRecipe(recipeName)
-							if err != nil {
-								return fmt.Errorf("recipe not found")
-							}
-							recipe, err := recipes.FetchRecipe(*rr)
-							if err != nil {
-								log.Fatalf("failed to fetch recipe '%s': %v", recipeName, err)
-							}
-							// check if installed
-							if recipes.IsInstalled(recipeName) {
-								fmt.Println("🔄 Skipping already installed recipe package: ", recipeName)
-								continue
+						plan.Print(os.Stdout, true)
+						if plan.Pending() > 0 {
+							if !cmd.Bool("yes") && !confirm(fmt.Sprintf("Uninstall %d packages?", plan.Pending())) {
+								fmt.Println("Aborted")
+								return nil
 							}
-							if err := recipes.RunRecipe(recipeName, recipe); err != nil {
-								log.Fatalf("recipe '%s' failed: %v", recipeName, err)
+							if err := install.Apply(ctx, plan); err != nil {
+								return err
 							}
 						}
 					}
+
+					removed, err := pkgConfig.Remove(pkgType, names)
+					if err != nil {
+						return err
+					}
+					for _, name := range names {
+						if !slices.Contains(removed, name) {
+							fmt.Printf("⚠️ %s is not in the %s section\n", name, pkgType)
+						}
+					}
+					if len(removed) > 0 {
+						fmt.Printf("📝 Removed from %s: %s\n", cmd.String("file"), strings.Join(removed, " "))
+					}
 					return nil
 				},
 			},
@@ -227,12 +584,54 @@ func main() {
 				},
 				Action: func(ctx context.Context, cmd *cli.Command) error {
 
-					return brew.InstallWithBundle(ctx, cmd.StringArgs("package"), brew.InstallOptions{
+					var bundle internal.Brew
+					for _, name := range cmd.StringArgs("package") {
+						bundle.Formulae = append(bundle.Formulae, internal.BrewFormula{Name: name})
+					}
+					return brew.InstallWithBundle(ctx, bundle, brew.InstallOptions{
 						Prefetch:     false, // default off; enable if you know cache is cold
 						NoAutoUpdate: true,
 					})
 				},
 			},
+			{
+				Name:  "snapshot",
+				Usage: "Write a packages.yml from what is installed on this machine",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "output",
+						Usage:   "file to write",
+						Value:   "packages.yml",
+						Aliases: []string{"o"},
+					},
+					&cli.BoolFlag{
+						Name:  "force",
+						Usage: "overwrite an existing file",
+					},
+				},
+				Arguments: []cli.Argument{
+					&cli.StringArg{
+						Name:  "name",
+						Value: "Snapshot",
+					},
+				},
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					out := cmd.String("output")
+					if _, err := os.Stat(out); err == nil && !cmd.Bool("force") {
+						return fmt.Errorf("%s already exists, use --force to overwrite it", out)
+					}
+					pkgs, err := install.Snapshot(ctx, cmd.StringArg("name"))
+					if err != nil {
+						log.Fatalf("failed to snapshot: %v", err)
+					}
+					if err := pkgs.SaveAs(out); err != nil {
+						return err
+					}
+					fmt.Printf("📸 Wrote %s: %d apt, %d flatpak, %d brew, %d recipes\n",
+						out, len(pkgs.Apt), len(pkgs.Flatpak), len(pkgs.Brew.Formulae), len(pkgs.Recipes))
+					return nil
+				},
+			},
 			{
 				Name:  "init",
 				Usage: "Initialize packages.yml",
@@ -1,11 +1,13 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"os"
 
 	"github.com/aottr/sth/internal/sthpkgs"
+	"github.com/aottr/sth/internal/utils"
 	"github.com/urfave/cli/v3"
 )
 
@@ -18,8 +20,183 @@ func main() {
 			{
 				Name:    "format",
 				Aliases: []string{"f", "fmt"},
-				Usage:   "format recipe files",
+				Usage:   "format recipe files, or every recipe below the recipes directory",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "check",
+						Usage: "only print a diff of unformatted recipes and fail if there are any",
+					},
+				},
+				Arguments: []cli.Argument{
+					&cli.StringArgs{
+						Name: "path",
+						Max:  -1,
+					},
+				},
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					files, err := recipeFiles(cmd.StringArgs("path"))
+					if err != nil {
+						return err
+					}
+					unformatted := 0
+					for _, f := range files {
+						data, err := os.ReadFile(f)
+						if err != nil {
+							return err
+						}
+						formatted, err := sthpkgs.FormatRecipe(data)
+						if err != nil {
+							return fmt.Errorf("%s: %w", f, err)
+						}
+						if bytes.Equal(data, formatted) {
+							continue
+						}
+						unformatted++
+						if cmd.Bool("check") {
+							fmt.Print(utils.UnifiedDiff(f, f+" (formatted)", string(data), string(formatted)))
+							continue
+						}
+						if err := utils.WriteFileAtomic(f, formatted, 0o644); err != nil {
+							return err
+						}
+						fmt.Printf("✏️  %s\n", f)
+					}
+					if cmd.Bool("check") && unformatted > 0 {
+						return fmt.Errorf("%d of %d recipes are not formatted, run sthpkgs format", unformatted, len(files))
+					}
+					return nil
+				},
+			},
+			{
+				Name:    "validate",
+				Aliases: []string{"v"},
+				Usage:   "check recipe files, or every recipe below the recipes directory",
+				Arguments: []cli.Argument{
+					&cli.StringArgs{
+						Name: "path",
+						Max:  -1,
+					},
+				},
 				Action: func(ctx context.Context, cmd *cli.Command) error {
+					files, err := recipeFiles(cmd.StringArgs("path"))
+					if err != nil {
+						return err
+					}
+
+					invalid := 0
+					for _, f := range files {
+						problems := sthpkgs.ValidateRecipeFile(f)
+						if len(problems) == 0 {
+							fmt.Printf("✅ %s\n", f)
+							continue
+						}
+						invalid++
+						fmt.Printf("❌ %s\n", f)
+						for _, p := range problems {
+							fmt.Printf("   %v\n", p)
+						}
+					}
+					if invalid > 0 {
+						return fmt.Errorf("%d of %d recipes are invalid", invalid, len(files))
+					}
+					return nil
+				},
+			},
+			{
+				Name:    "lint",
+				Aliases: []string{"l"},
+				Usage:   "check recipes against quality rules; silence a rule with `# sthpkgs:ignore <rule>`",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "strict",
+						Usage: "fail on warnings too",
+					},
+					&cli.BoolFlag{
+						Name:  "rules",
+						Usage: "list the rules and exit",
+					},
+				},
+				Arguments: []cli.Argument{
+					&cli.StringArgs{
+						Name: "path",
+						Max:  -1,
+					},
+				},
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					if cmd.Bool("rules") {
+						for _, r := range sthpkgs.LintRules {
+							fmt.Printf("%-26s %-8s %s\n", r.ID, r.Severity, r.Description)
+						}
+						return nil
+					}
+					files, err := recipeFiles(cmd.StringArgs("path"))
+					if err != nil {
+						return err
+					}
+					errs, warnings := 0, 0
+					for _, f := range files {
+						findings, err := sthpkgs.LintRecipeFile(f)
+						if err != nil {
+							return fmt.Errorf("%s: %w", f, err)
+						}
+						for _, finding := range findings {
+							icon := "⚠️ "
+							if finding.Severity == sthpkgs.SeverityError {
+								icon = "❌"
+								errs++
+							} else {
+								warnings++
+							}
+							fmt.Printf("%s %s:%d: %s [%s] %s: %s\n", icon, f, finding.Line, finding.Severity, finding.Rule, finding.Field, finding.Message)
+						}
+					}
+					if errs > 0 || (cmd.Bool("strict") && warnings > 0) {
+						return fmt.Errorf("%d errors and %d warnings in %d recipes", errs, warnings, len(files))
+					}
+					if errs+warnings == 0 {
+						fmt.Printf("✅ %d recipes passed\n", len(files))
+					}
+					return nil
+				},
+			},
+			{
+				Name:    "test",
+				Aliases: []string{"t"},
+				Usage:   "install recipes into a temporary root against a local fixture server, see test.yml",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "keep",
+						Usage: "keep the temporary root for inspection",
+					},
+				},
+				Arguments: []cli.Argument{
+					&cli.StringArgs{
+						Name: "path",
+						Max:  -1,
+					},
+				},
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					files, err := recipeFiles(cmd.StringArgs("path"))
+					if err != nil {
+						return err
+					}
+					failed := 0
+					for _, f := range files {
+						fmt.Printf("🧪 %s\n", f)
+						res, err := sthpkgs.TestRecipeFile(ctx, f, cmd.Bool("keep"))
+						if err != nil {
+							failed++
+							fmt.Printf("❌ %s: %v\n", f, err)
+							continue
+						}
+						fmt.Printf("✅ %s %s linked %v\n", f, res.Version, res.Binaries)
+						if cmd.Bool("keep") {
+							fmt.Printf("   root kept at %s\n", res.Root)
+						}
+					}
+					if failed > 0 {
+						return fmt.Errorf("%d of %d recipes failed", failed, len(files))
+					}
 					return nil
 				},
 			},
@@ -40,6 +217,21 @@ func main() {
 							return nil
 						},
 					},
+					{
+						Name:  "schema",
+						Usage: "generate recipe.schema.json",
+						Action: func(ctx context.Context, cmd *cli.Command) error {
+							out, err := sthpkgs.RecipeSchema()
+							if err != nil {
+								return err
+							}
+							if err := os.WriteFile("recipe.schema.json", out, 0o644); err != nil {
+								return err
+							}
+							fmt.Println("Wrote recipe.schema.json")
+							return nil
+						},
+					},
 				},
 			},
 		},
@@ -50,3 +242,24 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// recipeFiles expands directories in paths to the recipes below them.
+// Without paths, it finds the recipes below ./recipes.
+func recipeFiles(paths []string) ([]string, error) {
+	if len(paths) == 0 {
+		paths = []string{"recipes"}
+	}
+	var files []string
+	for _, p := range paths {
+		if info, err := os.Stat(p); err == nil && info.IsDir() {
+			found, err := sthpkgs.ScanRecipes(p)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, found...)
+			continue
+		}
+		files = append(files, p)
+	}
+	return files, nil
+}
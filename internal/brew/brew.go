@@ -10,6 +10,8 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/aottr/sth/internal"
 )
 
 type InstallOptions struct {
@@ -22,6 +24,15 @@ const (
 	PrefetchWorkers            = 3
 )
 
+// FormulaNames returns the names of formulae
+func FormulaNames(formulae []internal.BrewFormula) []string {
+	names := make([]string, 0, len(formulae))
+	for _, f := range formulae {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
 // normalizeFormula makes a formula comparable to `brew list --formula` output
 // converts "tap/name/name" or "tap/name" to "name"
 func normalizeFormula(n string) string {
@@ -33,33 +44,71 @@ func normalizeFormula(n string) string {
 	return parts[len(parts)-1]
 }
 
-// FilterInstalled returns only formulas that are not currently installed
-func FilterInstalled(ctx context.Context, names []string) ([]string, error) {
-	cmd := exec.CommandContext(ctx, "brew", "list", "--formula")
+// FilterInstalled returns only the taps, formulae and casks that are not currently installed
+func FilterInstalled(ctx context.Context, want internal.Brew) (internal.Brew, error) {
+	formulae, err := brewList(ctx, "list", "--formula")
+	if err != nil {
+		return internal.Brew{}, err
+	}
+	var casks map[string]struct{}
+	if len(want.Casks) > 0 {
+		if casks, err = brewList(ctx, "list", "--cask"); err != nil {
+			return internal.Brew{}, err
+		}
+	}
+	var taps map[string]struct{}
+	if len(want.Taps) > 0 {
+		if taps, err = brewList(ctx, "tap"); err != nil {
+			return internal.Brew{}, err
+		}
+	}
+
+	var missing internal.Brew
+	for _, t := range want.Taps {
+		name := strings.ToLower(strings.TrimSpace(t.Name))
+		if name == "" {
+			continue
+		}
+		if _, ok := taps[name]; !ok {
+			missing.Taps = append(missing.Taps, t)
+		}
+	}
+	for _, f := range want.Formulae {
+		if strings.TrimSpace(f.Name) == "" {
+			continue
+		}
+		if _, ok := formulae[normalizeFormula(f.Name)]; !ok {
+			missing.Formulae = append(missing.Formulae, f)
+		}
+	}
+	for _, c := range want.Casks {
+		if strings.TrimSpace(c.Name) == "" {
+			continue
+		}
+		if _, ok := casks[normalizeFormula(c.Name)]; !ok {
+			missing.Casks = append(missing.Casks, c)
+		}
+	}
+	return missing, nil
+}
+
+// brewList runs a brew listing command and returns its lines as a set
+func brewList(ctx context.Context, args ...string) (map[string]struct{}, error) {
+	cmd := exec.CommandContext(ctx, "brew", args...)
 	cmd.Env = append(os.Environ(), "HOMEBREW_NO_AUTO_UPDATE=1")
 	out, err := cmd.Output()
 	if err != nil {
-		return nil, fmt.Errorf("brew list failed: %w", err)
+		return nil, fmt.Errorf("brew %s failed: %w", strings.Join(args, " "), err)
 	}
 	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
-	installed := make(map[string]struct{}, len(lines)) // lets be memory efficient xD
+	set := make(map[string]struct{}, len(lines)) // lets be memory efficient xD
 	for _, line := range lines {
 		line = strings.TrimSpace(line)
 		if line != "" {
-			installed[line] = struct{}{}
+			set[line] = struct{}{}
 		}
 	}
-	var toInstall []string
-	for _, raw := range names {
-		if raw = strings.TrimSpace(raw); raw == "" {
-			continue
-		}
-		short := normalizeFormula(raw)
-		if _, ok := installed[short]; !ok {
-			toInstall = append(toInstall, raw)
-		}
-	}
-	return toInstall, nil
+	return set, nil
 }
 
 // cachedBottleExists returns true if brew reports a cache path that exists
@@ -151,66 +200,130 @@ func Prefetch(ctx context.Context, packages []string, workers int) {
 	fmt.Printf("[brew] prefetch completed\n")
 }
 
-// writeBrewfile creates a minimal Brewfile containing only "brew" lines
-func writeBrewfile(path string, formulas []string) error {
-	var b strings.Builder
-	for _, n := range formulas {
-		n = strings.TrimSpace(n)
-		if n == "" {
+// writeBrewfile creates a Brewfile with tap, brew and cask lines
+func writeBrewfile(path string, b internal.Brew) error {
+	var sb strings.Builder
+	for _, t := range b.Taps {
+		name := strings.TrimSpace(t.Name)
+		if name == "" {
+			continue
+		}
+		if t.URL != "" {
+			sb.WriteString(fmt.Sprintf("tap %q, %q\n", name, t.URL))
+		} else {
+			sb.WriteString(fmt.Sprintf("tap %q\n", name))
+		}
+	}
+	for _, f := range b.Formulae {
+		name := strings.TrimSpace(f.Name)
+		if name == "" {
+			continue
+		}
+		line := fmt.Sprintf("brew %q", name)
+		if len(f.Args) > 0 {
+			line += ", args: " + rubyStringList(f.Args)
+		}
+		if f.Link != nil {
+			line += fmt.Sprintf(", link: %t", *f.Link)
+		}
+		sb.WriteString(line + "\n")
+	}
+	for _, c := range b.Casks {
+		name := strings.TrimSpace(c.Name)
+		if name == "" {
 			continue
 		}
-		// b.WriteString(fmt.Sprintf("tap \"homebrew/core\"\n"))
-		b.WriteString(fmt.Sprintf("brew \"%s\"\n", n))
+		line := fmt.Sprintf("cask %q", name)
+		if len(c.Args) > 0 {
+			line += ", args: { " + rubyFlagHash(c.Args) + " }"
+		}
+		sb.WriteString(line + "\n")
+	}
+	return os.WriteFile(path, []byte(sb.String()), 0o644)
+}
+
+// rubyStringList renders ["a", "b"]
+func rubyStringList(vals []string) string {
+	quoted := make([]string, 0, len(vals))
+	for _, v := range vals {
+		quoted = append(quoted, fmt.Sprintf("%q", v))
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+// rubyFlagHash renders cask args such as "no-quarantine" or "appdir=~/Apps"
+// as `no_quarantine: true, appdir: "~/Apps"`
+func rubyFlagHash(vals []string) string {
+	parts := make([]string, 0, len(vals))
+	for _, v := range vals {
+		k, val, ok := strings.Cut(strings.TrimPrefix(v, "--"), "=")
+		k = strings.ReplaceAll(k, "-", "_")
+		if ok {
+			parts = append(parts, fmt.Sprintf("%s: %q", k, val))
+		} else {
+			parts = append(parts, k+": true")
+		}
 	}
-	return os.WriteFile(path, []byte(b.String()), 0o644)
+	return strings.Join(parts, ", ")
 }
 
 // InstallWithBundle generates a Brewfile and calls `brew bundle install`
-func InstallWithBundle(ctx context.Context, packages []string, opts InstallOptions) error {
+func InstallWithBundle(ctx context.Context, bundle internal.Brew, opts InstallOptions) error {
 	// Default NoAutoUpdate true
 	if !opts.NoAutoUpdate {
 		opts.NoAutoUpdate = true
 	}
 
-	fmt.Printf("[brew] resolving %d requested formulas...\n", len(packages))
-	toInstall, err := FilterInstalled(ctx, packages)
+	fmt.Printf("[brew] resolving %d taps, %d formulas and %d casks...\n", len(bundle.Taps), len(bundle.Formulae), len(bundle.Casks))
+	toInstall, err := FilterInstalled(ctx, bundle)
 	if err != nil {
 		return err
 	}
-	if len(toInstall) == 0 {
+	if toInstall.IsEmpty() {
 		fmt.Println("[brew] all requested formulas are already installed")
 		return nil
 	}
-	fmt.Printf("[brew] %d formulas need installation\n", len(toInstall))
+	return installBundle(ctx, toInstall, opts)
+}
+
+// installBundle installs toInstall, which must already be filtered, via
+// `brew bundle install` and retries failing entries individually
+func installBundle(ctx context.Context, toInstall internal.Brew, opts InstallOptions) error {
+	fmt.Printf("[brew] %d taps, %d formulas and %d casks need installation\n", len(toInstall.Taps), len(toInstall.Formulae), len(toInstall.Casks))
 
-	if opts.Prefetch && !(len(toInstall) < SmallInstallationThreshold) {
-		fmt.Printf("[brew] prefetching up to %d formulas with %d workers...\n", len(toInstall), PrefetchWorkers)
+	if opts.Prefetch && !(len(toInstall.Formulae) < SmallInstallationThreshold) {
+		fmt.Printf("[brew] prefetching up to %d formulas with %d workers...\n", len(toInstall.Formulae), PrefetchWorkers)
 		ctxFetch, cancel := context.WithTimeout(ctx, 30*time.Minute)
 		defer cancel()
-		Prefetch(ctxFetch, toInstall, PrefetchWorkers)
+		Prefetch(ctxFetch, FormulaNames(toInstall.Formulae), PrefetchWorkers)
 		fmt.Println("[brew] prefetch phase completed")
 	}
 
-	// gen temp Brewfile
-	tmpDir := os.TempDir()
-	brewfile := filepath.Join(tmpDir, "Brewfile.sth")
-	if err := writeBrewfile(brewfile, toInstall); err != nil {
-		return fmt.Errorf("failed to write Brewfile: %w", err)
+	report, err := runBundle(ctx, toInstall, opts)
+	if err != nil {
+		return err
 	}
+	if failed := report.Failed(); len(failed) > 0 {
+		fmt.Printf("[brew] %d items failed in bundle, retrying individually...\n", len(failed))
+		report.retry(ctx, failed, opts)
+	}
+	report.Print()
+	if failed := report.Failed(); len(failed) > 0 {
+		names := make([]string, 0, len(failed))
+		for _, it := range failed {
+			names = append(names, it.Name)
+		}
+		return fmt.Errorf("brew failed to install: %s", strings.Join(names, ", "))
+	}
+	fmt.Println("[brew] bundle install completed")
+	return nil
+}
 
-	args := []string{"bundle", "install", "--quiet", "--file", brewfile}
-	cmd := exec.CommandContext(ctx, "brew", args...)
-
+// brewEnv is the environment used for all installing brew commands
+func brewEnv(opts InstallOptions) []string {
 	env := append(os.Environ(), "HOMEBREW_NO_ENV_HINTS=1", "HOMEBREW_NO_INSTALL_CLEANUP=1", "HOMEBREW_INSTALL_BADGE=🦦")
 	if opts.NoAutoUpdate {
 		env = append(env, "HOMEBREW_NO_AUTO_UPDATE=1")
 	}
-	cmd.Env = env
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("brew bundle install failed: %w", err)
-	}
-	fmt.Println("[brew] bundle install completed")
-	return nil
+	return env
 }
@@ -0,0 +1,238 @@
+package brew
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/aottr/sth/internal"
+)
+
+type ItemKind string
+
+const (
+	KindTap     ItemKind = "tap"
+	KindFormula ItemKind = "brew"
+	KindCask    ItemKind = "cask"
+)
+
+type ItemStatus string
+
+const (
+	StatusInstalled ItemStatus = "installed"
+	StatusPresent   ItemStatus = "present" // "Using <name>" in bundle output
+	StatusFailed    ItemStatus = "failed"
+	StatusUnknown   ItemStatus = "unknown" // not mentioned in bundle output
+)
+
+// ItemResult is the outcome of a single Brewfile entry
+type ItemResult struct {
+	Kind   ItemKind
+	Name   string
+	Status ItemStatus
+	Output string // captured output of an individual retry
+}
+
+// BundleReport collects per-entry results of a `brew bundle install` run
+type BundleReport struct {
+	Items []ItemResult
+
+	formulae map[string]internal.BrewFormula
+	casks    map[string]internal.BrewCask
+	taps     map[string]internal.BrewTap
+}
+
+func newBundleReport(b internal.Brew) *BundleReport {
+	r := &BundleReport{
+		formulae: make(map[string]internal.BrewFormula, len(b.Formulae)),
+		casks:    make(map[string]internal.BrewCask, len(b.Casks)),
+		taps:     make(map[string]internal.BrewTap, len(b.Taps)),
+	}
+	for _, t := range b.Taps {
+		r.taps[t.Name] = t
+		r.Items = append(r.Items, ItemResult{Kind: KindTap, Name: t.Name, Status: StatusUnknown})
+	}
+	for _, f := range b.Formulae {
+		r.formulae[f.Name] = f
+		r.Items = append(r.Items, ItemResult{Kind: KindFormula, Name: f.Name, Status: StatusUnknown})
+	}
+	for _, c := range b.Casks {
+		r.casks[c.Name] = c
+		r.Items = append(r.Items, ItemResult{Kind: KindCask, Name: c.Name, Status: StatusUnknown})
+	}
+	return r
+}
+
+// Failed returns the entries that did not install
+func (r *BundleReport) Failed() []ItemResult {
+	var out []ItemResult
+	for _, it := range r.Items {
+		if it.Status == StatusFailed {
+			out = append(out, it)
+		}
+	}
+	return out
+}
+
+func (r *BundleReport) Print() {
+	for _, it := range r.Items {
+		icon := "✅"
+		switch it.Status {
+		case StatusFailed:
+			icon = "❌"
+		case StatusPresent:
+			icon = "🔄"
+		case StatusUnknown:
+			icon = "•"
+		}
+		fmt.Printf("[brew] %s %s %s: %s\n", icon, it.Kind, it.Name, it.Status)
+	}
+}
+
+func (r *BundleReport) set(name string, status ItemStatus) {
+	for i := range r.Items {
+		if r.Items[i].Name == name || normalizeFormula(r.Items[i].Name) == normalizeFormula(name) {
+			r.Items[i].Status = status
+			return
+		}
+	}
+}
+
+// parseLine understands the bundle progress lines:
+//
+//	Using wget
+//	Installing wget
+//	Installing wget has failed!
+//	Tapping hashicorp/tap
+//	Tapping hashicorp/tap has failed!
+func (r *BundleReport) parseLine(line string) {
+	line = strings.TrimSpace(line)
+	for _, verb := range []string{"Installing ", "Upgrading ", "Tapping "} {
+		rest, ok := strings.CutPrefix(line, verb)
+		if !ok {
+			continue
+		}
+		if name, failed := strings.CutSuffix(rest, " has failed!"); failed {
+			r.set(strings.TrimSpace(name), StatusFailed)
+		} else {
+			r.set(strings.TrimSpace(rest), StatusInstalled)
+		}
+		return
+	}
+	if name, ok := strings.CutPrefix(line, "Using "); ok {
+		r.set(strings.TrimSpace(name), StatusPresent)
+	}
+}
+
+// runBundle writes a unique temporary Brewfile, runs `brew bundle install`
+// and returns a per-entry report parsed from its output. A non-zero exit is
+// not an error by itself; failing entries are marked in the report.
+func runBundle(ctx context.Context, b internal.Brew, opts InstallOptions) (*BundleReport, error) {
+	f, err := os.CreateTemp("", "Brewfile.sth-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Brewfile: %w", err)
+	}
+	brewfile := f.Name()
+	f.Close()
+	defer os.Remove(brewfile)
+
+	if err := writeBrewfile(brewfile, b); err != nil {
+		return nil, fmt.Errorf("failed to write Brewfile: %w", err)
+	}
+
+	report := newBundleReport(b)
+	cmd := exec.CommandContext(ctx, "brew", "bundle", "install", "--verbose", "--file", brewfile)
+	cmd.Env = brewEnv(opts)
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("brew bundle install failed: %w", err)
+	}
+	scanLines(stdout, func(line string) {
+		fmt.Println(line)
+		report.parseLine(line)
+	})
+	runErr := cmd.Wait()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	if runErr != nil {
+		// entries never mentioned are treated as failed so they get retried
+		for i := range report.Items {
+			if report.Items[i].Status == StatusUnknown {
+				report.Items[i].Status = StatusFailed
+			}
+		}
+	} else {
+		for i := range report.Items {
+			if report.Items[i].Status == StatusUnknown {
+				report.Items[i].Status = StatusInstalled
+			}
+		}
+	}
+	return report, nil
+}
+
+// retry installs failed entries one by one so that one bad formula does not
+// fail the whole bundle
+func (r *BundleReport) retry(ctx context.Context, failed []ItemResult, opts InstallOptions) {
+	for _, it := range failed {
+		var args []string
+		switch it.Kind {
+		case KindTap:
+			args = []string{"tap", it.Name}
+			if t := r.taps[it.Name]; t.URL != "" {
+				args = append(args, t.URL)
+			}
+		case KindFormula:
+			args = []string{"install", "--formula"}
+			for _, a := range r.formulae[it.Name].Args {
+				args = append(args, "--"+strings.TrimPrefix(a, "--"))
+			}
+			args = append(args, it.Name)
+		case KindCask:
+			args = []string{"install", "--cask"}
+			for _, a := range r.casks[it.Name].Args {
+				args = append(args, "--"+strings.TrimPrefix(a, "--"))
+			}
+			args = append(args, it.Name)
+		}
+		fmt.Printf("[brew] retrying %s %s\n", it.Kind, it.Name)
+		cmd := exec.CommandContext(ctx, "brew", args...)
+		cmd.Env = brewEnv(opts)
+		out, err := cmd.CombinedOutput()
+		status := StatusInstalled
+		if err != nil {
+			status = StatusFailed
+		}
+		for i := range r.Items {
+			if r.Items[i].Kind == it.Kind && r.Items[i].Name == it.Name {
+				r.Items[i].Status = status
+				r.Items[i].Output = strings.TrimSpace(string(out))
+			}
+		}
+		if err != nil {
+			fmt.Printf("[brew] ❌ %s %s failed: %s\n", it.Kind, it.Name, lastLine(string(out)))
+		}
+	}
+}
+
+func scanLines(r io.Reader, fn func(string)) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fn(scanner.Text())
+	}
+}
+
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return lines[len(lines)-1]
+}
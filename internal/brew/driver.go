@@ -0,0 +1,211 @@
+package brew
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/aottr/sth/internal"
+	"github.com/aottr/sth/internal/installer"
+	"github.com/aottr/sth/internal/utils"
+)
+
+// Driver is the Homebrew installer
+type Driver struct {
+	Options InstallOptions
+}
+
+func New() *Driver {
+	return &Driver{Options: InstallOptions{NoAutoUpdate: true}}
+}
+
+func (d *Driver) Name() string { return string(internal.PackageTypeBrew) }
+
+func (d *Driver) Detect() bool {
+	_, err := exec.LookPath("brew")
+	return err == nil
+}
+
+// Installed lists installed formulae and casks with their versions
+func (d *Driver) Installed(ctx context.Context) ([]installer.Package, error) {
+	formulae, err := InstalledFormulae(ctx)
+	if err != nil {
+		return nil, err
+	}
+	casks, err := InstalledCasks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return append(formulae, casks...), nil
+}
+
+// InstalledFormulae lists installed formulae with their versions
+func InstalledFormulae(ctx context.Context) ([]installer.Package, error) {
+	return listVersions(ctx, "--formula")
+}
+
+// InstalledCasks lists installed casks with their versions
+func InstalledCasks(ctx context.Context) ([]installer.Package, error) {
+	return listVersions(ctx, "--cask")
+}
+
+func listVersions(ctx context.Context, kind string) ([]installer.Package, error) {
+	cmd := exec.CommandContext(ctx, "brew", "list", kind, "--versions")
+	cmd.Env = append(os.Environ(), "HOMEBREW_NO_AUTO_UPDATE=1")
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("brew list %s failed: %w", kind, err)
+	}
+	var pkgs []installer.Package
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		p := installer.Package{Name: fields[0]}
+		if len(fields) > 1 {
+			p.Version = fields[len(fields)-1]
+		}
+		pkgs = append(pkgs, p)
+	}
+	return pkgs, nil
+}
+
+func (d *Driver) Plan(ctx context.Context, desired *internal.Packages) ([]installer.Change, error) {
+	want := desired.Brew
+	if want.IsEmpty() {
+		return nil, nil
+	}
+	if !d.Detect() {
+		var names []string
+		for _, t := range want.Taps {
+			names = append(names, t.Name)
+		}
+		names = append(names, FormulaNames(want.Formulae)...)
+		for _, c := range want.Casks {
+			names = append(names, c.Name)
+		}
+		return installer.SkipAll(d.Name(), names, "brew not available"), nil
+	}
+
+	missing, err := FilterInstalled(ctx, want)
+	if err != nil {
+		return nil, err
+	}
+	isMissing := make(map[string]struct{})
+	for _, t := range missing.Taps {
+		isMissing["tap:"+t.Name] = struct{}{}
+	}
+	for _, f := range missing.Formulae {
+		isMissing["brew:"+f.Name] = struct{}{}
+	}
+	for _, c := range missing.Casks {
+		isMissing["cask:"+c.Name] = struct{}{}
+	}
+	change := func(kind ItemKind, name string, spec any) installer.Change {
+		c := installer.Change{Manager: d.Name(), Name: name, Spec: spec, Action: installer.ActionSkip, Reason: "installed"}
+		if kind != KindFormula {
+			c.Details = []string{string(kind)}
+		}
+		if _, ok := isMissing[string(kind)+":"+name]; ok {
+			c.Action = installer.ActionInstall
+			c.Reason = ""
+		}
+		return c
+	}
+
+	var changes []installer.Change
+	for _, t := range want.Taps {
+		changes = append(changes, change(KindTap, t.Name, t))
+	}
+	for _, f := range want.Formulae {
+		changes = append(changes, change(KindFormula, f.Name, f))
+	}
+	for _, c := range want.Casks {
+		changes = append(changes, change(KindCask, c.Name, c))
+	}
+	return changes, nil
+}
+
+// Apply installs planned entries through one Brewfile and uninstalls removed ones
+func (d *Driver) Apply(ctx context.Context, plan []installer.Change) error {
+	var bundle internal.Brew
+	for _, c := range installer.ByAction(plan, installer.ActionInstall, installer.ActionUpgrade) {
+		switch spec := c.Spec.(type) {
+		case internal.BrewTap:
+			bundle.Taps = append(bundle.Taps, spec)
+		case internal.BrewFormula:
+			bundle.Formulae = append(bundle.Formulae, spec)
+		case internal.BrewCask:
+			bundle.Casks = append(bundle.Casks, spec)
+		}
+	}
+	if !bundle.IsEmpty() {
+		opts := d.Options
+		opts.Prefetch = len(bundle.Formulae) > 20
+		if err := installBundle(ctx, bundle, opts); err != nil {
+			return err
+		}
+	}
+
+	for _, c := range installer.ByAction(plan, installer.ActionRemove) {
+		var args []string
+		switch c.Spec.(type) {
+		case internal.BrewTap:
+			args = []string{"untap", c.Name}
+		case internal.BrewCask:
+			args = []string{"uninstall", "--cask", c.Name}
+		default:
+			args = []string{"uninstall", "--formula", c.Name}
+		}
+		fmt.Printf("[brew] 🗑️ %s %s\n", args[0], c.Name)
+		cmd := exec.CommandContext(ctx, "brew", args...)
+		cmd.Env = brewEnv(d.Options)
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("brew %s %s failed: %w", args[0], c.Name, err)
+		}
+	}
+	return nil
+}
+
+// Drift plans the uninstall of formulae installed on request (`brew leaves
+// --installed-on-request`) that are not declared
+func (d *Driver) Drift(ctx context.Context, desired *internal.Packages) ([]installer.Change, error) {
+	if !d.Detect() {
+		return nil, nil
+	}
+	leaves, err := Leaves(ctx)
+	if err != nil {
+		return nil, err
+	}
+	declared := make(map[string]struct{}, len(desired.Brew.Formulae))
+	for _, f := range desired.Brew.Formulae {
+		// tapped formulae may be declared as user/tap/name
+		declared[f.Name] = struct{}{}
+		declared[path.Base(f.Name)] = struct{}{}
+	}
+	var changes []installer.Change
+	for _, name := range leaves {
+		if _, ok := declared[name]; ok {
+			continue
+		}
+		if _, ok := declared[path.Base(name)]; ok {
+			continue
+		}
+		changes = append(changes, installer.Change{Manager: d.Name(), Action: installer.ActionRemove, Name: name, Reason: "not declared", Spec: internal.BrewFormula{Name: name}})
+	}
+	return changes, nil
+}
+
+// Leaves lists formulae installed on request that no other formula depends on
+func Leaves(ctx context.Context) ([]string, error) {
+	leaves, err := brewList(ctx, "leaves", "--installed-on-request")
+	if err != nil {
+		return nil, err
+	}
+	return utils.SortedKeys(leaves), nil
+}
@@ -0,0 +1,106 @@
+package cargo
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/aottr/sth/internal"
+	"github.com/aottr/sth/internal/installer"
+	"github.com/aottr/sth/internal/utils"
+)
+
+// Installed returns installed crates and their versions via `cargo install --list`
+func Installed(ctx context.Context) (map[string]string, error) {
+	out, err := exec.CommandContext(ctx, "cargo", "install", "--list").Output()
+	if err != nil {
+		return nil, fmt.Errorf("cargo install --list failed: %w", err)
+	}
+	return parseList(string(out)), nil
+}
+
+// parseList reads crate header lines and skips the indented binaries:
+//
+//	ripgrep v14.1.0:
+//	    rg
+//	cargo-edit v0.12.2 (https://github.com/...):
+func parseList(out string) map[string]string {
+	installed := make(map[string]string)
+	for _, line := range strings.Split(out, "\n") {
+		if line == "" || strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			continue
+		}
+		fields := strings.Fields(strings.TrimSuffix(line, ":"))
+		if len(fields) < 2 {
+			continue
+		}
+		installed[fields[0]] = strings.TrimSuffix(strings.TrimPrefix(fields[1], "v"), ":")
+	}
+	return installed
+}
+
+// Driver installs crates with `cargo install`
+type Driver struct{}
+
+func New() *Driver { return &Driver{} }
+
+func (d *Driver) Name() string { return string(internal.PackageTypeCargo) }
+
+func (d *Driver) Detect() bool {
+	_, err := exec.LookPath("cargo")
+	return err == nil
+}
+
+func (d *Driver) Installed(ctx context.Context) ([]installer.Package, error) {
+	installed, err := Installed(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return installer.Packages(installed), nil
+}
+
+func (d *Driver) Plan(ctx context.Context, desired *internal.Packages) ([]installer.Change, error) {
+	if len(desired.Cargo) == 0 {
+		return nil, nil
+	}
+	if !d.Detect() {
+		return installer.SkipAll(d.Name(), utils.SortedKeys(desired.Cargo), "cargo not available"), nil
+	}
+	installed, err := Installed(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return installer.PlanVersions(d.Name(), desired.Cargo, installed), nil
+}
+
+// Apply installs crates that are missing or do not match their pinned version
+func (d *Driver) Apply(ctx context.Context, plan []installer.Change) error {
+	for _, c := range installer.ByAction(plan, installer.ActionInstall, installer.ActionUpgrade) {
+		args := []string{"install", "--locked", c.Name}
+		if c.To != "" {
+			args = append(args, "--version", strings.TrimPrefix(c.To, "v"))
+		}
+		fmt.Printf("📦 Installing crate: %s\n", c.Name)
+		if err := run(ctx, args...); err != nil {
+			return fmt.Errorf("cargo install %s failed: %w", c.Name, err)
+		}
+	}
+	return Uninstall(ctx, installer.Names(installer.ByAction(plan, installer.ActionRemove)))
+}
+
+// Uninstall removes the named crates
+func Uninstall(ctx context.Context, names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	fmt.Printf("🗑️ Uninstalling crates: %s\n", strings.Join(names, " "))
+	return run(ctx, append([]string{"uninstall"}, names...)...)
+}
+
+func run(ctx context.Context, args ...string) error {
+	cmd := exec.CommandContext(ctx, "cargo", args...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	return cmd.Run()
+}
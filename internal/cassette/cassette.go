@@ -0,0 +1,200 @@
+// Package cassette records the HTTP responses sth receives to a directory
+// and replays them later, so runs can be reproduced offline. It is selected
+// with STH_HTTP_MODE=record|replay; the directory is STH_HTTP_CASSETTE.
+package cassette
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aottr/sth/internal/utils"
+)
+
+const (
+	ModeEnv = "STH_HTTP_MODE"
+	DirEnv  = "STH_HTTP_CASSETTE"
+
+	// DefaultDir is the cassette directory without STH_HTTP_CASSETTE, in the
+	// working directory so it can be attached to a bug report
+	DefaultDir = "sth-cassette"
+)
+
+type Mode string
+
+const (
+	ModeLive   Mode = ""
+	ModeRecord Mode = "record"
+	ModeReplay Mode = "replay"
+)
+
+// skippedHeaders are response headers that are not recorded
+var skippedHeaders = []string{"Set-Cookie", "Date", "Content-Length", "Connection"}
+
+// Cassette is a directory of recorded responses, one metadata file and one
+// body file per method and URL
+type Cassette struct {
+	Dir  string
+	Mode Mode
+	// Base makes the real requests when recording, default http.DefaultTransport
+	Base http.RoundTripper
+}
+
+// entry is the metadata of a recorded response
+type entry struct {
+	Method   string      `json:"method"`
+	URL      string      `json:"url"`
+	Status   int         `json:"status"`
+	Header   http.Header `json:"header,omitempty"`
+	Recorded time.Time   `json:"recorded"`
+}
+
+var defaultTransport = sync.OnceValue(func() http.RoundTripper {
+	mode := Mode(strings.ToLower(strings.TrimSpace(os.Getenv(ModeEnv))))
+	dir := strings.TrimSpace(os.Getenv(DirEnv))
+	if dir == "" {
+		dir = DefaultDir
+	}
+	switch mode {
+	case ModeLive:
+		return http.DefaultTransport
+	case ModeRecord, ModeReplay:
+		return &Cassette{Dir: dir, Mode: mode}
+	}
+	// a typo must not silently go online, e.g. in an air-gapped demo
+	return failingTransport{fmt.Errorf("invalid %s %q, use record or replay", ModeEnv, mode)}
+})
+
+// Transport returns the transport selected by STH_HTTP_MODE, the default
+// transport when it is unset
+func Transport() http.RoundTripper { return defaultTransport() }
+
+// Client returns a client with timeout using Transport
+func Client(timeout time.Duration) *http.Client {
+	return &http.Client{Timeout: timeout, Transport: Transport()}
+}
+
+// RoundTrip replays the recorded response to req, or makes the request and
+// records its response
+func (c *Cassette) RoundTrip(req *http.Request) (*http.Response, error) {
+	meta, body := c.paths(req)
+	if c.Mode == ModeReplay {
+		return c.replay(req, meta, body)
+	}
+
+	base := c.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	header := resp.Header.Clone()
+	for _, h := range skippedHeaders {
+		header.Del(h)
+	}
+	e := entry{Method: req.Method, URL: req.URL.String(), Status: resp.StatusCode, Header: header, Recorded: time.Now().UTC()}
+	if err := c.write(meta, body, e, resp.Body); err != nil {
+		return nil, fmt.Errorf("cassette: record %s: %w", e.URL, err)
+	}
+	// the body is served from the recording, so downloads never sit in memory
+	f, err := os.Open(body)
+	if err != nil {
+		return nil, err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	resp.Body = f
+	resp.ContentLength = st.Size()
+	return resp, nil
+}
+
+func (c *Cassette) replay(req *http.Request, meta, body string) (*http.Response, error) {
+	raw, err := os.ReadFile(meta)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("cassette: no recorded response for %s %s in %s, record it with %s=record", req.Method, req.URL, c.Dir, ModeEnv)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var e entry
+	if err := json.Unmarshal(raw, &e); err != nil {
+		return nil, fmt.Errorf("cassette: %s: %w", meta, err)
+	}
+	f, err := os.Open(body)
+	if err != nil {
+		return nil, fmt.Errorf("cassette: %w", err)
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("cassette: %w", err)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", e.Status, http.StatusText(e.Status)),
+		StatusCode:    e.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        e.Header,
+		Body:          f,
+		ContentLength: st.Size(),
+		Request:       req,
+	}, nil
+}
+
+// write streams the body to a temporary file next to the recording and
+// renames it into place, then writes the metadata
+func (c *Cassette) write(meta, body string, e entry, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(meta), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(body), "."+filepath.Base(body)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), body); err != nil {
+		return err
+	}
+	raw, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(meta, append(raw, '\n'), 0o644)
+}
+
+// paths returns the metadata and body files of req, grouped by host. The
+// request headers are not part of the key, so tokens never end up in it.
+func (c *Cassette) paths(req *http.Request) (string, string) {
+	sum := sha256.Sum256([]byte(req.Method + " " + req.URL.String()))
+	base := filepath.Join(c.Dir, req.URL.Hostname(), hex.EncodeToString(sum[:8]))
+	return base + ".json", base + ".body"
+}
+
+type failingTransport struct{ err error }
+
+func (t failingTransport) RoundTrip(*http.Request) (*http.Response, error) { return nil, t.err }
@@ -0,0 +1,226 @@
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aottr/sth/internal"
+	"github.com/aottr/sth/internal/installer"
+	"github.com/aottr/sth/internal/utils"
+)
+
+// SourceBrewCask holds brew casks, apart from formulae of the same name
+const SourceBrewCask = "brew-cask"
+
+// Sources is the order in which sources are reported
+var Sources = []string{
+	string(internal.PackageTypeApt),
+	string(internal.PackageTypeFlatpak),
+	string(internal.PackageTypeSnap),
+	string(internal.PackageTypeBrew),
+	SourceBrewCask,
+	string(internal.PackageTypePipx),
+	string(internal.PackageTypeCargo),
+	string(internal.PackageTypeGo),
+	string(internal.PackageTypeNpm),
+	string(internal.PackageTypeRecipe),
+}
+
+// Entries maps source -> package name -> version. An empty version means any.
+type Entries map[string]map[string]string
+
+// Set records name with version under source
+func (e Entries) Set(source, name, version string) {
+	if e[source] == nil {
+		e[source] = make(map[string]string)
+	}
+	e[source][name] = version
+}
+
+// FromPackages flattens a packages config into entries
+func FromPackages(p *internal.Packages) Entries {
+	e := Entries{}
+	for name, v := range p.Apt {
+		e.Set(string(internal.PackageTypeApt), name, v)
+	}
+	for _, ref := range p.Flatpak {
+		e.Set(string(internal.PackageTypeFlatpak), ref.ID, ref.Branch)
+	}
+	for _, s := range p.Snap {
+		e.Set(string(internal.PackageTypeSnap), s.Name, s.Revision)
+	}
+	for _, f := range p.Brew.Formulae {
+		e.Set(string(internal.PackageTypeBrew), f.Name, "")
+	}
+	for _, c := range p.Brew.Casks {
+		e.Set(SourceBrewCask, c.Name, "")
+	}
+	for source, m := range map[internal.PackageType]map[string]string{
+		internal.PackageTypePipx:  p.Pipx,
+		internal.PackageTypeCargo: p.Cargo,
+		internal.PackageTypeGo:    p.Go,
+		internal.PackageTypeNpm:   p.Npm,
+	} {
+		for name, v := range m {
+			e.Set(string(source), name, v)
+		}
+	}
+	for _, r := range p.Recipes {
+		e.Set(string(internal.PackageTypeRecipe), r, "")
+	}
+	return e
+}
+
+type Kind string
+
+const (
+	KindAdded   Kind = "added"
+	KindRemoved Kind = "removed"
+	KindChanged Kind = "changed"
+)
+
+// Change is a single difference between two sets of entries
+type Change struct {
+	Source string `json:"source"`
+	Kind   Kind   `json:"kind"`
+	Name   string `json:"name"`
+	From   string `json:"from,omitempty"`
+	To     string `json:"to,omitempty"`
+}
+
+// Result lists the changes from A to B, ordered by source and name
+type Result struct {
+	A       string   `json:"a"`
+	B       string   `json:"b"`
+	Changes []Change `json:"changes"`
+
+	// "<source> <name> <version>" lines of both sides for WriteUnified.
+	// Matching entries get the same line on both sides.
+	aLines, bLines []string
+}
+
+// MatchFunc reports whether two versions of the same package are equal
+type MatchFunc func(a, b string) bool
+
+// Exact compares versions as written
+func Exact(a, b string) bool { return a == b }
+
+// Satisfies treats a as the version declared in packages.yml and b as the
+// installed one
+func Satisfies(declared, installed string) bool {
+	c := installer.ParseConstraint(declared)
+	if c.IsLatest() {
+		return true
+	}
+	if c.Op == installer.OpGe {
+		want, okW := utils.ParseSemVer(strings.TrimPrefix(c.Value, "v"))
+		have, okH := utils.ParseSemVer(strings.TrimPrefix(installed, "v"))
+		return okW && okH && utils.CmpSemVer(have, want) >= 0
+	}
+	// debian revisions, e.g. 1.2.3-1ubuntu1 for a pin of 1.2.3
+	return utils.VersionSatisfies(installed, c.Value) || strings.HasPrefix(installed, c.Value+"-")
+}
+
+// Compare returns the changes from a to b
+func Compare(aName string, a Entries, bName string, b Entries, match MatchFunc) *Result {
+	r := &Result{A: aName, B: bName, Changes: []Change{}}
+	for _, source := range Sources {
+		as, bs := a[source], b[source]
+		names := make(map[string]struct{}, len(as)+len(bs))
+		for n := range as {
+			names[n] = struct{}{}
+		}
+		for n := range bs {
+			names[n] = struct{}{}
+		}
+		for _, name := range utils.SortedKeys(names) {
+			av, inA := as[name]
+			bv, inB := bs[name]
+			if inA {
+				r.aLines = append(r.aLines, source+" "+withVersion(name, av))
+			}
+			if inB && inA && match(av, bv) {
+				r.bLines = append(r.bLines, source+" "+withVersion(name, av))
+			} else if inB {
+				r.bLines = append(r.bLines, source+" "+withVersion(name, bv))
+			}
+			switch {
+			case !inA:
+				r.Changes = append(r.Changes, Change{Source: source, Kind: KindAdded, Name: name, To: bv})
+			case !inB:
+				r.Changes = append(r.Changes, Change{Source: source, Kind: KindRemoved, Name: name, From: av})
+			case !match(av, bv):
+				r.Changes = append(r.Changes, Change{Source: source, Kind: KindChanged, Name: name, From: av, To: bv})
+			}
+		}
+	}
+	return r
+}
+
+// Empty reports whether a and b are equal
+func (r *Result) Empty() bool { return len(r.Changes) == 0 }
+
+const (
+	colorRed    = "\033[31m"
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[33m"
+	colorReset  = "\033[0m"
+)
+
+// Print writes the changes grouped by source
+func (r *Result) Print(w io.Writer, color bool) {
+	paint := func(c, s string) string {
+		if !color {
+			return s
+		}
+		return c + s + colorReset
+	}
+	source := ""
+	for _, c := range r.Changes {
+		if c.Source != source {
+			source = c.Source
+			fmt.Fprintln(w, source)
+		}
+		switch c.Kind {
+		case KindAdded:
+			fmt.Fprintln(w, paint(colorGreen, "  + "+withVersion(c.Name, c.To)))
+		case KindRemoved:
+			fmt.Fprintln(w, paint(colorRed, "  - "+withVersion(c.Name, c.From)))
+		case KindChanged:
+			fmt.Fprintln(w, paint(colorYellow, fmt.Sprintf("  ~ %s %s -> %s", c.Name, orAny(c.From), orAny(c.To))))
+		}
+	}
+}
+
+// WriteJSON writes the result as JSON
+func (r *Result) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteUnified writes both sides as lines of the form
+// "<source> <name> <version>" and the changes as a unified diff of them
+func (r *Result) WriteUnified(w io.Writer) {
+	fmt.Fprint(w, utils.UnifiedDiff(r.A, r.B, joinLines(r.aLines), joinLines(r.bLines)))
+}
+
+func joinLines(lines []string) string {
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func withVersion(name, version string) string {
+	if version == "" {
+		return name
+	}
+	return name + " " + version
+}
+
+func orAny(v string) string {
+	return utils.WithDefault(v, "*")
+}
@@ -0,0 +1,46 @@
+package diff
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aottr/sth/internal"
+)
+
+func TestWriteUnified(t *testing.T) {
+	a := Entries{"apt": {"curl": "7.0", "git": "latest"}, "brew": {"jq": ""}}
+	b := Entries{"apt": {"curl": "8.0", "git": "2.43.0", "htop": "3.3.0"}, "brew": {"jq": "1.7"}}
+	var out strings.Builder
+	Compare("packages.yml", a, "live", b, Satisfies).WriteUnified(&out)
+	want := `--- packages.yml
++++ live
+@@ -1,3 +1,4 @@
+-apt curl 7.0
++apt curl 8.0
+ apt git latest
++apt htop 3.3.0
+ brew jq
+`
+	if out.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", out.String(), want)
+	}
+
+	out.Reset()
+	Compare("a", a, "b", a, Exact).WriteUnified(&out)
+	if out.Len() != 0 {
+		t.Errorf("equal entries: got %q", out.String())
+	}
+}
+
+func TestFromPackagesCasks(t *testing.T) {
+	p := &internal.Packages{}
+	p.Brew.Formulae = []internal.BrewFormula{{Name: "docker"}}
+	p.Brew.Casks = []internal.BrewCask{{Name: "docker"}}
+	e := FromPackages(p)
+	if _, ok := e["brew"]["docker"]; !ok {
+		t.Error("formula docker missing")
+	}
+	if _, ok := e[SourceBrewCask]["docker"]; !ok {
+		t.Error("cask docker missing")
+	}
+}
@@ -1,20 +1,339 @@
 package flatpak
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"os/exec"
+	"strings"
 
-	"github.com/aottr/sth/internal/utils"
+	"github.com/aottr/sth/internal"
+	"github.com/aottr/sth/internal/installer"
 )
 
-func InstallFlatpak(pkgs []string) error {
-	if len(pkgs) == 0 {
+const DefaultRemote = "flathub"
+
+const (
+	InstallationSystem = "system"
+	InstallationUser   = "user"
+)
+
+// InstalledRef is one row of `flatpak list`
+type InstalledRef struct {
+	ID           string
+	Branch       string
+	Origin       string
+	Installation string // "system" or "user"
+}
+
+// Driver installs flatpak refs. Refs removed from packages.yml are
+// uninstalled by Drift, under `sth sync --prune`.
+type Driver struct {
+	Remotes []internal.FlatpakRemote
+	Refs    []internal.FlatpakRef
+}
+
+func New() *Driver {
+	return &Driver{}
+}
+
+func (d *Driver) Name() string { return string(internal.PackageTypeFlatpak) }
+
+func (d *Driver) Detect() bool {
+	_, err := exec.LookPath("flatpak")
+	return err == nil
+}
+
+func (d *Driver) Installed(ctx context.Context) ([]installer.Package, error) {
+	refs, err := Installed(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pkgs := make([]installer.Package, 0, len(refs))
+	for _, r := range refs {
+		pkgs = append(pkgs, installer.Package{Name: r.ID, Version: r.Branch})
+	}
+	return pkgs, nil
+}
+
+// Plan adds missing remotes, installs missing refs and re-applies overrides.
+// Undeclared refs are only removed by Drift, under sync --prune.
+func (d *Driver) Plan(ctx context.Context, desired *internal.Packages) ([]installer.Change, error) {
+	d.Remotes = desired.FlatpakRemotes
+	d.Refs = desired.Flatpak
+	if !d.Detect() {
+		return installer.SkipAll(d.Name(), internal.FlatpakIDs(d.Refs), "flatpak not available"), nil
+	}
+
+	var changes []installer.Change
+	if len(d.Remotes) > 0 {
+		existing, err := remotes(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range d.Remotes {
+			c := installer.Change{Manager: d.Name(), Name: r.Name, Reason: "flatpak remote", Spec: r}
+			if _, ok := existing[installationName(r.User)+"/"+r.Name]; ok {
+				c.Action = installer.ActionSkip
+			} else {
+				c.Action = installer.ActionConfigure
+				c.Details = []string{"remote-add " + r.Name + " " + r.URL}
+			}
+			changes = append(changes, c)
+		}
+	}
+
+	installed, err := Installed(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range d.Refs {
+		c := installer.Change{Manager: d.Name(), Name: ref.ID, To: ref.Branch, Spec: ref}
+		if isInstalled(installed, ref) {
+			c.Action = installer.ActionSkip
+			c.Reason = "installed"
+		} else {
+			c.Action = installer.ActionInstall
+			c.Details = []string{"from " + remoteOf(ref) + " (" + installationName(ref.User) + ")"}
+		}
+		changes = append(changes, c)
+		if len(ref.Overrides) == 0 {
+			continue
+		}
+		oc := installer.Change{Manager: d.Name(), Action: installer.ActionConfigure, Name: ref.ID, Reason: "flatpak override", Details: ref.Overrides, Spec: ref}
+		if c.Action == installer.ActionSkip {
+			missing, err := missingOverrides(ctx, ref)
+			if err != nil {
+				return nil, err
+			}
+			if len(missing) == 0 {
+				oc.Action = installer.ActionSkip
+				oc.Reason = "overrides applied"
+			}
+			oc.Details = missing
+		}
+		changes = append(changes, oc)
+	}
+	return changes, nil
+}
+
+// Apply adds remotes, installs refs with one flatpak call per
+// installation/remote pair, applies overrides and uninstalls removed refs.
+func (d *Driver) Apply(ctx context.Context, plan []installer.Change) error {
+	var refs, removed []internal.FlatpakRef
+	for _, c := range plan {
+		switch spec := c.Spec.(type) {
+		case internal.FlatpakRemote:
+			if c.Action == installer.ActionConfigure {
+				if err := addRemote(ctx, spec); err != nil {
+					return err
+				}
+			}
+		case internal.FlatpakRef:
+			switch {
+			case c.Action == installer.ActionInstall:
+				refs = append(refs, spec)
+			case c.Action == installer.ActionConfigure && !containsRef(refs, spec):
+				if err := applyOverrides(ctx, spec); err != nil {
+					return err
+				}
+			case c.Action == installer.ActionRemove:
+				removed = append(removed, spec)
+			}
+		}
+	}
+	if err := install(ctx, refs); err != nil {
+		return err
+	}
+	// overrides of freshly installed refs are planned before they exist
+	for _, c := range installer.ByAction(plan, installer.ActionConfigure) {
+		if ref, ok := c.Spec.(internal.FlatpakRef); ok && containsRef(refs, ref) {
+			if err := applyOverrides(ctx, ref); err != nil {
+				return err
+			}
+		}
+	}
+	return uninstall(ctx, removed)
+}
+
+// install installs refs, one flatpak call per installation/remote pair
+func install(ctx context.Context, refs []internal.FlatpakRef) error {
+	if len(refs) == 0 {
 		return nil
 	}
-	for _, pkg := range pkgs {
-		fmt.Printf("📦 Installing flatpak: %s\n", pkg)
-		if _, err := utils.RunCommand("flatpak", "install", "-y", pkg); err != nil {
-			return err
+	type group struct {
+		user   bool
+		remote string
+	}
+	groups := make(map[group][]string)
+	var order []group
+	for _, ref := range refs {
+		g := group{user: ref.User, remote: remoteOf(ref)}
+		if _, ok := groups[g]; !ok {
+			order = append(order, g)
+		}
+		groups[g] = append(groups[g], refString(ref))
+	}
+
+	for _, g := range order {
+		fmt.Printf("📦 Installing flatpaks from %s: %s\n", g.remote, strings.Join(groups[g], " "))
+		args := append([]string{"install", "--noninteractive", "--assumeyes", "--or-update", installationFlag(g.user), g.remote}, groups[g]...)
+		if err := run(ctx, args...); err != nil {
+			return fmt.Errorf("flatpak install from %s failed: %w", g.remote, err)
 		}
 	}
 	return nil
 }
+
+// uninstall removes the given refs
+func uninstall(ctx context.Context, refs []internal.FlatpakRef) error {
+	if len(refs) == 0 {
+		return nil
+	}
+	for _, ref := range refs {
+		fmt.Printf("🗑️ Uninstalling flatpak: %s\n", ref.ID)
+		if err := run(ctx, "uninstall", "--noninteractive", "--assumeyes", installationFlag(ref.User), refString(ref)); err != nil {
+			return fmt.Errorf("flatpak uninstall %s failed: %w", ref.ID, err)
+		}
+	}
+	return nil
+}
+
+func addRemote(ctx context.Context, r internal.FlatpakRemote) error {
+	if strings.TrimSpace(r.Name) == "" || strings.TrimSpace(r.URL) == "" {
+		return fmt.Errorf("flatpak remote needs name and url")
+	}
+	if err := run(ctx, "remote-add", "--if-not-exists", installationFlag(r.User), r.Name, r.URL); err != nil {
+		return fmt.Errorf("flatpak remote-add %s failed: %w", r.Name, err)
+	}
+	return nil
+}
+
+// remotes returns the configured remotes as "<installation>/<name>"
+func remotes(ctx context.Context) (map[string]struct{}, error) {
+	out, err := exec.CommandContext(ctx, "flatpak", "remotes", "--columns=name,options").Output()
+	if err != nil {
+		return nil, fmt.Errorf("flatpak remotes failed: %w", err)
+	}
+	set := make(map[string]struct{})
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) < 2 || fields[0] == "" {
+			continue
+		}
+		inst := InstallationSystem
+		if strings.Contains(fields[1], InstallationUser) {
+			inst = InstallationUser
+		}
+		set[inst+"/"+strings.TrimSpace(fields[0])] = struct{}{}
+	}
+	return set, nil
+}
+
+func containsRef(refs []internal.FlatpakRef, ref internal.FlatpakRef) bool {
+	for _, r := range refs {
+		if r.ID == ref.ID && r.User == ref.User {
+			return true
+		}
+	}
+	return false
+}
+
+// Installed lists installed applications via `flatpak list --columns`
+func Installed(ctx context.Context) ([]InstalledRef, error) {
+	cmd := exec.CommandContext(ctx, "flatpak", "list", "--app", "--columns=application,branch,origin,installation")
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("flatpak list failed: %w", err)
+	}
+	var refs []InstalledRef
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) < 4 || fields[0] == "" {
+			continue
+		}
+		refs = append(refs, InstalledRef{
+			ID:           strings.TrimSpace(fields[0]),
+			Branch:       strings.TrimSpace(fields[1]),
+			Origin:       strings.TrimSpace(fields[2]),
+			Installation: strings.TrimSpace(fields[3]),
+		})
+	}
+	return refs, nil
+}
+
+func isInstalled(installed []InstalledRef, ref internal.FlatpakRef) bool {
+	for _, in := range installed {
+		if in.ID != ref.ID || in.Installation != installationName(ref.User) {
+			continue
+		}
+		if ref.Branch != "" && in.Branch != ref.Branch {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+func remoteOf(ref internal.FlatpakRef) string {
+	if ref.Remote != "" {
+		return ref.Remote
+	}
+	return DefaultRemote
+}
+
+// refString returns "<id>//<branch>" when a branch is pinned
+func refString(ref internal.FlatpakRef) string {
+	if ref.Branch != "" {
+		return ref.ID + "//" + ref.Branch
+	}
+	return ref.ID
+}
+
+func installationName(user bool) string {
+	if user {
+		return InstallationUser
+	}
+	return InstallationSystem
+}
+
+func installationFlag(user bool) string {
+	return "--" + installationName(user)
+}
+
+func run(ctx context.Context, args ...string) error {
+	cmd := exec.CommandContext(ctx, "flatpak", args...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	return cmd.Run()
+}
+
+// Drift plans the removal of installed applications that are not declared,
+// whether sth installed them or not
+func (d *Driver) Drift(ctx context.Context, desired *internal.Packages) ([]installer.Change, error) {
+	d.Remotes = desired.FlatpakRemotes
+	d.Refs = desired.Flatpak
+	if !d.Detect() {
+		return nil, nil
+	}
+	installed, err := Installed(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var changes []installer.Change
+	for _, in := range installed {
+		ref := internal.FlatpakRef{ID: in.ID, User: in.Installation == InstallationUser}
+		if containsRef(d.Refs, ref) {
+			continue
+		}
+		changes = append(changes, installer.Change{
+			Manager: d.Name(),
+			Action:  installer.ActionRemove,
+			Name:    in.ID,
+			From:    in.Branch,
+			Reason:  "not declared",
+			Details: []string{in.Installation + " installation"},
+			Spec:    ref,
+		})
+	}
+	return changes, nil
+}
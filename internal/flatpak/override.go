@@ -0,0 +1,109 @@
+package flatpak
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/aottr/sth/internal"
+)
+
+func applyOverrides(ctx context.Context, ref internal.FlatpakRef) error {
+	if len(ref.Overrides) == 0 {
+		return nil
+	}
+	args := append([]string{"override", installationFlag(ref.User)}, ref.Overrides...)
+	args = append(args, ref.ID)
+	if err := run(ctx, args...); err != nil {
+		return fmt.Errorf("flatpak override %s failed: %w", ref.ID, err)
+	}
+	return nil
+}
+
+// missingOverrides returns the overrides of ref that `flatpak override
+// --show` does not list yet
+func missingOverrides(ctx context.Context, ref internal.FlatpakRef) ([]string, error) {
+	out, err := exec.CommandContext(ctx, "flatpak", "override", installationFlag(ref.User), "--show", ref.ID).Output()
+	if err != nil {
+		return nil, fmt.Errorf("flatpak override --show %s failed: %w", ref.ID, err)
+	}
+	applied := parseOverrides(string(out))
+	var missing []string
+	for _, o := range ref.Overrides {
+		if !overrideApplied(applied, o) {
+			missing = append(missing, o)
+		}
+	}
+	return missing, nil
+}
+
+// keyfile maps "<group>/<key>" of a flatpak keyfile to its value
+type keyfile map[string]string
+
+func parseOverrides(out string) keyfile {
+	kf := make(keyfile)
+	group := ""
+	sc := bufio.NewScanner(strings.NewReader(out))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			group = line[1 : len(line)-1]
+		default:
+			if k, v, ok := strings.Cut(line, "="); ok {
+				kf[group+"/"+k] = v
+			}
+		}
+	}
+	return kf
+}
+
+// contextKeys maps override flags to their list in the Context group, the
+// negated flag adds the value with a "!"
+var contextKeys = map[string]string{
+	"share": "shared", "unshare": "!shared",
+	"socket": "sockets", "nosocket": "!sockets",
+	"device": "devices", "nodevice": "!devices",
+	"allow": "features", "disallow": "!features",
+	"filesystem": "filesystems", "nofilesystem": "!filesystems",
+	"persist": "persistent",
+}
+
+// busPolicies maps bus name flags to their group and policy
+var busPolicies = map[string][2]string{
+	"talk-name": {"Session Bus Policy", "talk"}, "own-name": {"Session Bus Policy", "own"}, "no-talk-name": {"Session Bus Policy", "none"},
+	"system-talk-name": {"System Bus Policy", "talk"}, "system-own-name": {"System Bus Policy", "own"}, "system-no-talk-name": {"System Bus Policy", "none"},
+}
+
+// overrideApplied reports whether the override flag o, e.g.
+// "--filesystem=home:ro", is part of kf. Unknown flags never are, so they
+// are applied again.
+func overrideApplied(kf keyfile, o string) bool {
+	flag, value, _ := strings.Cut(strings.TrimPrefix(o, "--"), "=")
+	if key, ok := contextKeys[flag]; ok {
+		if k, negated := strings.CutPrefix(key, "!"); negated {
+			key, value = k, "!"+value
+		}
+		if key == "filesystems" {
+			value = strings.TrimSuffix(value, ":rw")
+		}
+		for _, v := range strings.Split(kf["Context/"+key], ";") {
+			if v == value {
+				return true
+			}
+		}
+		return false
+	}
+	if p, ok := busPolicies[flag]; ok {
+		return kf[p[0]+"/"+value] == p[1]
+	}
+	if flag == "env" {
+		k, v, _ := strings.Cut(value, "=")
+		got, ok := kf["Environment/"+k]
+		return ok && got == v
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+package flatpak
+
+import "testing"
+
+func TestOverrideApplied(t *testing.T) {
+	kf := parseOverrides(`[Context]
+shared=network;!ipc;
+filesystems=~/Games;xdg-download:ro;!home;
+devices=dri;
+
+[Environment]
+GTK_THEME=Adwaita:dark
+
+[Session Bus Policy]
+org.freedesktop.Flatpak=talk
+`)
+	tests := []struct {
+		override string
+		want     bool
+	}{
+		{"--share=network", true},
+		{"--unshare=ipc", true},
+		{"--unshare=network", false},
+		{"--filesystem=~/Games", true},
+		{"--filesystem=~/Games:rw", true},
+		{"--filesystem=xdg-download:ro", true},
+		{"--filesystem=xdg-download", false},
+		{"--nofilesystem=home", true},
+		{"--filesystem=home", false},
+		{"--device=dri", true},
+		{"--socket=wayland", false},
+		{"--env=GTK_THEME=Adwaita:dark", true},
+		{"--env=GTK_THEME=Adwaita", false},
+		{"--talk-name=org.freedesktop.Flatpak", true},
+		{"--own-name=org.freedesktop.Flatpak", false},
+		{"--system-talk-name=org.freedesktop.Flatpak", false},
+		{"--unknown=x", false},
+	}
+	for _, tt := range tests {
+		if got := overrideApplied(kf, tt.override); got != tt.want {
+			t.Errorf("overrideApplied(%q) = %v, want %v", tt.override, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package gotool
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/aottr/sth/internal"
+	"github.com/aottr/sth/internal/installer"
+	"github.com/aottr/sth/internal/utils"
+)
+
+var majorSuffix = regexp.MustCompile(`^v[0-9]+$`)
+
+// BinName returns the binary `go install` builds for a package path,
+// e.g. "golang.org/x/tools/gopls" -> "gopls", "github.com/a/b/v2" -> "b"
+func BinName(pkg string) string {
+	pkg = strings.TrimSuffix(pkg, "/")
+	base := path.Base(pkg)
+	if majorSuffix.MatchString(base) {
+		base = path.Base(path.Dir(pkg))
+	}
+	return base
+}
+
+// BinDir returns GOBIN or GOPATH/bin
+func BinDir(ctx context.Context) (string, error) {
+	out, err := exec.CommandContext(ctx, "go", "env", "GOBIN", "GOPATH").Output()
+	if err != nil {
+		return "", fmt.Errorf("go env failed: %w", err)
+	}
+	// one line per variable, the GOBIN line is empty when it is unset
+	lines := strings.Split(strings.TrimRight(string(out), "\r\n"), "\n")
+	if gobin := strings.TrimSpace(lines[0]); gobin != "" {
+		return gobin, nil
+	}
+	if len(lines) > 1 && strings.TrimSpace(lines[1]) != "" {
+		gopath := strings.Split(strings.TrimSpace(lines[1]), string(os.PathListSeparator))[0]
+		return filepath.Join(gopath, "bin"), nil
+	}
+	return "", fmt.Errorf("cannot determine go bin directory")
+}
+
+// InstalledVersion reads the module version of an installed binary via
+// `go version -m`. An empty version means the binary is not installed.
+func InstalledVersion(ctx context.Context, binDir, pkg string) (string, error) {
+	bin := filepath.Join(binDir, BinName(pkg))
+	if _, err := os.Stat(bin); err != nil {
+		return "", nil
+	}
+	out, err := exec.CommandContext(ctx, "go", "version", "-m", bin).Output()
+	if err != nil {
+		return "", fmt.Errorf("go version -m %s failed: %w", bin, err)
+	}
+	// "\tmod\tgolang.org/x/tools/gopls\tv0.16.0\th1:..."
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 3 && fields[0] == "mod" {
+			return fields[2], nil
+		}
+	}
+	return "(devel)", nil
+}
+
+// Installed returns the installed version for each of pkgs that has a binary
+func Installed(ctx context.Context, pkgs []string) (map[string]string, error) {
+	binDir, err := BinDir(ctx)
+	if err != nil {
+		return nil, err
+	}
+	installed := make(map[string]string, len(pkgs))
+	for _, pkg := range pkgs {
+		v, err := InstalledVersion(ctx, binDir, pkg)
+		if err != nil {
+			return nil, err
+		}
+		if v != "" {
+			installed[pkg] = v
+		}
+	}
+	return installed, nil
+}
+
+// Driver installs binaries with `go install`
+type Driver struct{}
+
+func New() *Driver { return &Driver{} }
+
+func (d *Driver) Name() string { return string(internal.PackageTypeGo) }
+
+func (d *Driver) Detect() bool {
+	_, err := exec.LookPath("go")
+	return err == nil
+}
+
+// Installed lists the go binaries in the bin directory that carry module info
+func (d *Driver) Installed(ctx context.Context) ([]installer.Package, error) {
+	binDir, err := BinDir(ctx)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(binDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var pkgs []installer.Package
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		out, err := exec.CommandContext(ctx, "go", "version", "-m", filepath.Join(binDir, e.Name())).Output()
+		if err != nil {
+			continue
+		}
+		var pkg, ver string
+		for _, line := range strings.Split(string(out), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) >= 2 && fields[0] == "path" {
+				pkg = fields[1]
+			}
+			if len(fields) >= 3 && fields[0] == "mod" {
+				ver = fields[2]
+			}
+		}
+		if pkg != "" {
+			pkgs = append(pkgs, installer.Package{Name: pkg, Version: ver})
+		}
+	}
+	return pkgs, nil
+}
+
+func (d *Driver) Plan(ctx context.Context, desired *internal.Packages) ([]installer.Change, error) {
+	if len(desired.Go) == 0 {
+		return nil, nil
+	}
+	names := utils.SortedKeys(desired.Go)
+	if !d.Detect() {
+		return installer.SkipAll(d.Name(), names, "go not available"), nil
+	}
+	installed, err := Installed(ctx, names)
+	if err != nil {
+		return nil, err
+	}
+	return installer.PlanVersions(d.Name(), desired.Go, installed), nil
+}
+
+// Apply runs `go install pkg@version` for every planned package
+func (d *Driver) Apply(ctx context.Context, plan []installer.Change) error {
+	for _, c := range installer.ByAction(plan, installer.ActionInstall, installer.ActionUpgrade) {
+		version := "latest"
+		if c.To != "" {
+			version = "v" + strings.TrimPrefix(c.To, "v")
+		}
+		fmt.Printf("📦 Installing go binary: %s@%s\n", c.Name, version)
+		cmd := exec.CommandContext(ctx, "go", "install", c.Name+"@"+version)
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("go install %s failed: %w", c.Name, err)
+		}
+	}
+	if remove := installer.ByAction(plan, installer.ActionRemove); len(remove) > 0 {
+		return Uninstall(ctx, installer.Names(remove))
+	}
+	return nil
+}
+
+// Uninstall deletes the binaries of pkgs from the go bin directory
+func Uninstall(ctx context.Context, pkgs []string) error {
+	binDir, err := BinDir(ctx)
+	if err != nil {
+		return err
+	}
+	for _, pkg := range pkgs {
+		bin := filepath.Join(binDir, BinName(pkg))
+		fmt.Printf("🗑️ Removing go binary: %s\n", bin)
+		if err := os.Remove(bin); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,354 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/aottr/sth/internal/cassette"
+	"github.com/aottr/sth/internal/platform"
+	"github.com/aottr/sth/internal/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// Include is another packages file merged into this one. In packages.yml it
+// is either a path or a mapping; HTTPS URLs must be pinned by sha256.
+type Include struct {
+	Path   string `yaml:"path,omitempty"`   // relative to the including file
+	URL    string `yaml:"url,omitempty"`    // https only
+	SHA256 string `yaml:"sha256,omitempty"` // required for url
+}
+
+func (i *Include) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		if strings.HasPrefix(value.Value, "https://") || strings.HasPrefix(value.Value, "http://") {
+			i.URL = value.Value
+		} else {
+			i.Path = value.Value
+		}
+		return nil
+	}
+	type plain Include
+	return value.Decode((*plain)(i))
+}
+
+func (i Include) MarshalYAML() (any, error) {
+	if i.URL == "" && i.SHA256 == "" {
+		return i.Path, nil
+	}
+	type plain Include
+	return plain(i), nil
+}
+
+// includeFetchTimeout bounds downloading a single URL include
+const includeFetchTimeout = 30 * time.Second
+
+// includeSet merges included files. Entries may appear in several includes
+// as long as they are identical; anything else is a conflict.
+type includeSet struct {
+	sections Sections
+	profiles map[string]Sections
+	hosts    map[string]Sections
+
+	// origins maps "<scope>/<section>/<name>" to the include that declared it
+	origins map[string]string
+	// visiting detects include cycles
+	visiting map[string]bool
+	loaded   []string
+	skipped  []Skipped
+}
+
+// applyIncludes merges the includes of p, recursively and in order, and puts
+// p's own entries on top of them
+func (p *Packages) applyIncludes() error {
+	if len(p.Include) == 0 {
+		return nil
+	}
+	set := &includeSet{origins: map[string]string{}, visiting: map[string]bool{}}
+	self, err := filepath.Abs(p.path)
+	if err != nil {
+		return err
+	}
+	set.visiting[self] = true
+	for _, inc := range p.Include {
+		if err := set.load(inc, filepath.Dir(self), ""); err != nil {
+			return err
+		}
+	}
+
+	own := p.Sections
+	p.Sections = set.sections
+	p.Sections.merge(own)
+	p.Profiles = mergeNamed(set.profiles, p.Profiles)
+	p.Hosts = mergeNamed(set.hosts, p.Hosts)
+	p.Included = set.loaded
+	p.skipped = append(set.skipped, p.skipped...)
+	return nil
+}
+
+// load reads one include relative to dir, or to baseURL for includes of a
+// URL include, and merges it and its own includes
+func (s *includeSet) load(inc Include, dir, baseURL string) error {
+	var (
+		data []byte
+		name string
+		err  error
+	)
+	switch {
+	case inc.URL != "":
+		name = inc.URL
+		if s.visiting[name] {
+			return fmt.Errorf("include cycle at %s", name)
+		}
+		data, err = fetchInclude(inc.URL, inc.SHA256)
+	case inc.Path != "" && baseURL != "":
+		ref, perr := url.Parse(inc.Path)
+		if perr != nil {
+			return fmt.Errorf("include %s: %w", inc.Path, perr)
+		}
+		base, _ := url.Parse(baseURL)
+		return s.load(Include{URL: base.ResolveReference(ref).String(), SHA256: inc.SHA256}, dir, baseURL)
+	case inc.Path != "":
+		name = inc.Path
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(dir, name)
+		}
+		if s.visiting[name] {
+			return fmt.Errorf("include cycle at %s", name)
+		}
+		data, err = os.ReadFile(name)
+	default:
+		return fmt.Errorf("include needs a path or url")
+	}
+	if err != nil {
+		return fmt.Errorf("include %s: %w", name, err)
+	}
+
+	var included Packages
+	skipped, err := decodePackages(data, &included, platform.GetPlatformInfo())
+	if err != nil {
+		return fmt.Errorf("include %s: %w", name, err)
+	}
+	s.skipped = append(s.skipped, skipped...)
+
+	s.visiting[name] = true
+	defer delete(s.visiting, name)
+	for _, nested := range included.Include {
+		if inc.URL != "" {
+			err = s.load(nested, "", inc.URL)
+		} else {
+			err = s.load(nested, filepath.Dir(name), "")
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := s.add("", &s.sections, included.Sections, name); err != nil {
+		return err
+	}
+	for _, key := range utils.SortedKeys(included.Profiles) {
+		if s.profiles == nil {
+			s.profiles = map[string]Sections{}
+		}
+		sec := s.profiles[key]
+		if err := s.add("profile "+key, &sec, included.Profiles[key], name); err != nil {
+			return err
+		}
+		s.profiles[key] = sec
+	}
+	for _, key := range utils.SortedKeys(included.Hosts) {
+		if s.hosts == nil {
+			s.hosts = map[string]Sections{}
+		}
+		sec := s.hosts[key]
+		if err := s.add("host "+key, &sec, included.Hosts[key], name); err != nil {
+			return err
+		}
+		s.hosts[key] = sec
+	}
+	s.loaded = append(s.loaded, name)
+	return nil
+}
+
+// add merges src from include name into dst, failing on conflicting entries
+func (s *includeSet) add(scope string, dst *Sections, src Sections, name string) error {
+	var err error
+	strictMap := func(section string, d, add map[string]string) map[string]string {
+		for _, k := range utils.SortedKeys(add) {
+			if err != nil {
+				break
+			}
+			v := add[k]
+			if cur, ok := d[k]; ok && !sameVersion(cur, v) {
+				err = s.conflict(scope, section, k, name, fmt.Sprintf("%q vs %q", cur, v))
+			}
+		}
+		return mergeMap(d, add)
+	}
+	dst.Apt = strictMap("apt", dst.Apt, src.Apt)
+	dst.Pipx = strictMap("pipx", dst.Pipx, src.Pipx)
+	dst.Cargo = strictMap("cargo", dst.Cargo, src.Cargo)
+	dst.Go = strictMap("go", dst.Go, src.Go)
+	dst.Npm = strictMap("npm", dst.Npm, src.Npm)
+	if err != nil {
+		return err
+	}
+
+	for _, check := range []error{
+		strictList(s, scope, "flatpak", dst.Flatpak, src.Flatpak, name, func(r FlatpakRef) string { return r.ID }),
+		strictList(s, scope, "snap", dst.Snap, src.Snap, name, func(p SnapPackage) string { return p.Name }),
+		strictList(s, scope, "brew tap", dst.Brew.Taps, src.Brew.Taps, name, func(t BrewTap) string { return t.Name }),
+		strictList(s, scope, "brew formula", dst.Brew.Formulae, src.Brew.Formulae, name, func(f BrewFormula) string { return f.Name }),
+		strictList(s, scope, "brew cask", dst.Brew.Casks, src.Brew.Casks, name, func(c BrewCask) string { return c.Name }),
+		strictList(s, scope, "apt repository", dst.AptRepositories, src.AptRepositories, name, func(r AptRepository) string { return r.Name }),
+		strictList(s, scope, "flatpak remote", dst.FlatpakRemotes, src.FlatpakRemotes, name, func(r FlatpakRemote) string { return r.Name }),
+	} {
+		if check != nil {
+			return check
+		}
+	}
+	for _, k := range utils.SortedKeys(src.Tools) {
+		if cur, ok := dst.Tools[k]; ok && !reflect.DeepEqual(cur, src.Tools[k]) {
+			return s.conflict(scope, "tool", k, name, "different names")
+		}
+		s.record(scope, "tool", k, name)
+	}
+	for _, section := range []string{"apt", "pipx", "cargo", "go", "npm"} {
+		for k := range sectionMap(src, section) {
+			s.record(scope, section, k, name)
+		}
+	}
+	dst.merge(src)
+	return nil
+}
+
+// strictList fails when add has an entry whose key is in dst with different content
+func strictList[T any](s *includeSet, scope, section string, dst, add []T, name string, key func(T) string) error {
+	existing := make(map[string]T, len(dst))
+	for _, item := range dst {
+		existing[key(item)] = item
+	}
+	for _, item := range add {
+		k := key(item)
+		if cur, ok := existing[k]; ok && !reflect.DeepEqual(cur, item) {
+			return s.conflict(scope, section, k, name, "different options")
+		}
+		s.record(scope, section, k, name)
+	}
+	return nil
+}
+
+func sectionMap(s Sections, section string) map[string]string {
+	switch section {
+	case "apt":
+		return s.Apt
+	case "pipx":
+		return s.Pipx
+	case "cargo":
+		return s.Cargo
+	case "go":
+		return s.Go
+	case "npm":
+		return s.Npm
+	}
+	return nil
+}
+
+func (s *includeSet) record(scope, section, key, name string) {
+	id := scope + "/" + section + "/" + key
+	if _, ok := s.origins[id]; !ok {
+		s.origins[id] = name
+	}
+}
+
+func (s *includeSet) conflict(scope, section, key, name, detail string) error {
+	where := section
+	if scope != "" {
+		where = scope + " " + section
+	}
+	return fmt.Errorf("include conflict: %s %s is declared by %s and %s with %s", where, key, s.origins[scope+"/"+section+"/"+key], name, detail)
+}
+
+// sameVersion treats the ways of saying "any version" as equal
+func sameVersion(a, b string) bool {
+	latest := func(v string) bool { return !utils.IsPinned(v) }
+	return a == b || (latest(a) && latest(b))
+}
+
+// mergeNamed merges own profiles or hosts on top of the included ones
+func mergeNamed(included, own map[string]Sections) map[string]Sections {
+	if len(included) == 0 {
+		return own
+	}
+	out := make(map[string]Sections, len(included)+len(own))
+	for k, v := range included {
+		out[k] = v
+	}
+	for k, v := range own {
+		sec := out[k]
+		sec.merge(v)
+		out[k] = sec
+	}
+	return out
+}
+
+// fetchInclude downloads an include and checks it against its pin. Verified
+// files are cached by hash, so later loads work offline.
+func fetchInclude(rawURL, sum string) ([]byte, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "https" {
+		return nil, fmt.Errorf("only https URLs can be included")
+	}
+	sum = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(sum, "sha256:")))
+	if len(sum) != sha256.Size*2 {
+		return nil, fmt.Errorf("URL includes must be pinned with a sha256")
+	}
+
+	cacheFile := ""
+	if dir, err := os.UserCacheDir(); err == nil {
+		cacheFile = filepath.Join(dir, "sth", "includes", sum+".yml")
+		if data, err := os.ReadFile(cacheFile); err == nil && checksum(data) == sum {
+			return data, nil
+		}
+	}
+
+	client := cassette.Client(includeFetchTimeout)
+	resp, err := client.Get(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to GET: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if got := checksum(data); got != sum {
+		return nil, fmt.Errorf("sha256 mismatch: expected %s, got %s", sum, got)
+	}
+	if cacheFile != "" {
+		if err := os.MkdirAll(filepath.Dir(cacheFile), 0o755); err == nil {
+			_ = utils.WriteFileAtomic(cacheFile, data, 0o644)
+		}
+	}
+	return data, nil
+}
+
+func checksum(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
@@ -0,0 +1,72 @@
+package install
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/aottr/sth/internal"
+	"github.com/aottr/sth/internal/installer"
+)
+
+// DefaultProtected are kept by `sth sync --prune` even if packages.yml does
+// not protect them, as removing them can leave a machine unbootable or
+// unreachable
+var DefaultProtected = []string{
+	"apt:sudo",
+	"apt:ca-certificates",
+	"apt:openssh-server",
+	"apt:network-manager",
+	"apt:linux-image-*",
+	"apt:linux-headers-*",
+	"apt:linux-generic*",
+	"apt:grub*",
+	"apt:shim-signed",
+	"apt:systemd*",
+	"apt:*-desktop",
+}
+
+// Drift plans the removal of everything installed on request that
+// packages.yml does not declare. Protected packages are planned as skips.
+func Drift(ctx context.Context, pkgs *internal.Packages, installers []installer.Installer) (*Plan, error) {
+	protected := append(append([]string{}, DefaultProtected...), pkgs.Protected...)
+	plan := &Plan{}
+	for _, inst := range installers {
+		pruner, ok := inst.(installer.Pruner)
+		if !ok {
+			continue
+		}
+		changes, err := pruner.Drift(ctx, pkgs)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", inst.Name(), err)
+		}
+		if len(changes) == 0 {
+			continue
+		}
+		for i, c := range changes {
+			if isProtected(protected, c.Manager, c.Name) {
+				changes[i].Action = installer.ActionSkip
+				changes[i].Reason = "protected"
+			}
+		}
+		plan.Managers = append(plan.Managers, ManagerPlan{Manager: inst.Name(), Changes: changes, installer: inst})
+	}
+	return plan, nil
+}
+
+// isProtected matches name against patterns of the form "[manager:]glob"
+func isProtected(patterns []string, manager, name string) bool {
+	for _, p := range patterns {
+		if m, glob, ok := strings.Cut(p, ":"); ok {
+			if m != manager {
+				continue
+			}
+			p = glob
+		}
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
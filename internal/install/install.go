@@ -2,61 +2,318 @@ package install
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
-	"os"
-	"os/exec"
+	"io"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/aottr/sth/internal"
 	"github.com/aottr/sth/internal/brew"
+	"github.com/aottr/sth/internal/cargo"
+	"github.com/aottr/sth/internal/flatpak"
+	"github.com/aottr/sth/internal/gotool"
+	"github.com/aottr/sth/internal/installer"
+	"github.com/aottr/sth/internal/native"
+	"github.com/aottr/sth/internal/native/apt"
+	"github.com/aottr/sth/internal/npm"
+	"github.com/aottr/sth/internal/pipx"
+	"github.com/aottr/sth/internal/platform"
+	"github.com/aottr/sth/internal/recipes"
+	"github.com/aottr/sth/internal/snap"
+	"github.com/aottr/sth/internal/utils"
 )
 
-type Spec struct {
-	BrewFormulas []string
-	AptPackages  []string
-	Flatpaks     []string
+// ManagerPlan is the plan of a single installer
+type ManagerPlan struct {
+	Manager string             `json:"manager"`
+	Changes []installer.Change `json:"changes"`
+
+	installer installer.Installer
 }
 
-func runFlatpak(ctx context.Context, refs []string) error {
-	if len(refs) == 0 {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-			return nil
+// Plan is what sth would do to bring the machine in line with packages.yml
+type Plan struct {
+	Managers []ManagerPlan `json:"managers"`
+}
+
+// Installers returns every installer for this machine. The native package
+// manager comes first and recipes last, as recipes may depend on both.
+func Installers() []installer.Installer {
+	var list []installer.Installer
+	if d, err := native.GetDriverForRelease(platform.GetPlatformInfo().Family); err == nil {
+		list = append(list, d)
+	}
+	return append(list,
+		flatpak.New(),
+		snap.New(),
+		brew.New(),
+		pipx.New(),
+		cargo.New(),
+		gotool.New(),
+		npm.New(),
+		recipes.New(),
+	)
+}
+
+// InstallerFor returns the installer handling a packages.yml section
+func InstallerFor(name string) (installer.Installer, bool) {
+	for _, inst := range Installers() {
+		if inst.Name() == name {
+			return inst, true
 		}
 	}
-	args := append([]string{"install", "--noninteractive", "--assumeyes", "--or-update", "flathub"}, refs...)
-	cmd := exec.CommandContext(ctx, "flatpak", args...)
-	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
-	return cmd.Run()
+	return nil, false
 }
 
-func runBrew(ctx context.Context, formulas []string) error {
-	if len(formulas) == 0 {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-			return nil
+// MakePlan asks every installer for its changes without executing anything
+func MakePlan(ctx context.Context, pkgs *internal.Packages, installers []installer.Installer) (*Plan, error) {
+	plan := &Plan{}
+	hasNative := false
+	for _, inst := range installers {
+		if inst.Name() == string(internal.PackageTypeApt) {
+			hasNative = true
+		}
+		changes, err := inst.Plan(ctx, pkgs)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", inst.Name(), err)
+		}
+		if len(changes) == 0 {
+			continue
+		}
+		plan.Managers = append(plan.Managers, ManagerPlan{Manager: inst.Name(), Changes: changes, installer: inst})
+	}
+	plan.addSkipped(pkgs.Skipped)
+	if !hasNative && len(pkgs.Apt) > 0 {
+		reason := fmt.Sprintf("unsupported system: %s", platform.GetPlatformInfo().Family)
+		changes := installer.SkipAll(string(internal.PackageTypeApt), utils.SortedKeys(pkgs.Apt), reason)
+		plan.Managers = append([]ManagerPlan{{Manager: string(internal.PackageTypeApt), Changes: changes}}, plan.Managers...)
+	}
+	return plan, nil
+}
+
+// RemovalPlan plans the uninstall of the named packages that inst finds
+// installed, and of what the installer removes along with them. pkgs must
+// still declare them, as their specs come from the plan. apt packages are
+// purged.
+func RemovalPlan(ctx context.Context, pkgs *internal.Packages, inst installer.Installer, names []string) (*Plan, error) {
+	switch d := inst.(type) {
+	case *recipes.Driver:
+		d.Offline = true
+	case *apt.DebianDriver:
+		d.Purge = true
+	}
+	plan, err := MakePlan(ctx, pkgs, []installer.Installer{inst})
+	if err != nil {
+		return nil, err
+	}
+	plan = plan.Filter(names)
+	notApplicable := make(map[string]struct{})
+	for _, s := range pkgs.Skipped {
+		notApplicable[s.Manager+"/"+s.Name] = struct{}{}
+	}
+	for i, m := range plan.Managers {
+		if m.installer == nil || !m.installer.Detect() {
+			plan.Managers[i].Changes = nil
+			continue
+		}
+		var changes []installer.Change
+		for _, c := range m.Changes {
+			if _, ok := notApplicable[c.Manager+"/"+c.Name]; ok {
+				continue
+			}
+			switch c.Action {
+			case installer.ActionSkip, installer.ActionHold, installer.ActionUpgrade:
+				c.Action = installer.ActionRemove
+				c.To = ""
+				c.Reason = ""
+				c.Details = nil
+				changes = append(changes, c)
+			}
+		}
+		if r, ok := m.installer.(installer.Remover); ok {
+			if changes, err = r.Removals(ctx, changes); err != nil {
+				return nil, fmt.Errorf("%s: %w", m.Manager, err)
+			}
+		}
+		plan.Managers[i].Changes = changes
+	}
+	return plan, nil
+}
+
+// addSkipped explains entries left out on this machine
+func (p *Plan) addSkipped(skipped []internal.Skipped) {
+	for _, s := range skipped {
+		c := installer.Change{Manager: s.Manager, Action: installer.ActionNotApplicable, Name: s.Name, Reason: s.Reason}
+		found := false
+		for i := range p.Managers {
+			if p.Managers[i].Manager == s.Manager {
+				p.Managers[i].Changes = append(p.Managers[i].Changes, c)
+				found = true
+				break
+			}
+		}
+		if !found {
+			p.Managers = append(p.Managers, ManagerPlan{Manager: s.Manager, Changes: []installer.Change{c}})
+		}
+	}
+}
+
+// Pending returns the number of changes that do something
+func (p *Plan) Pending() int {
+	n := 0
+	for _, m := range p.Managers {
+		n += len(installer.Pending(m.Changes))
+	}
+	return n
+}
+
+// Filter keeps only the changes for the given names, e.g. what `sth add` added
+func (p *Plan) Filter(names []string) *Plan {
+	keep := make(map[string]struct{}, len(names))
+	for _, n := range names {
+		keep[n] = struct{}{}
+	}
+	out := &Plan{}
+	for _, m := range p.Managers {
+		var changes []installer.Change
+		for _, c := range m.Changes {
+			if _, ok := keep[c.Name]; ok {
+				changes = append(changes, c)
+			}
+		}
+		if len(changes) > 0 {
+			out.Managers = append(out.Managers, ManagerPlan{Manager: m.Manager, Changes: changes, installer: m.installer})
 		}
 	}
+	return out
+}
+
+// WriteJSON writes the plan for tools that show it before applying
+func (p *Plan) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(p)
+}
 
-	return brew.InstallWithBundle(ctx, formulas, brew.InstallOptions{
-		Prefetch:     len(formulas) > 20,
-		NoAutoUpdate: true,
-	})
+var actionIcons = map[installer.Action]string{
+	installer.ActionInstall:   "+",
+	installer.ActionUpgrade:   "~",
+	installer.ActionRemove:    "-",
+	installer.ActionConfigure: "*",
+	installer.ActionHold:      "=",
+	installer.ActionSkip:      "·",
+
+	installer.ActionNotApplicable: "/",
 }
 
-func InstallAll(spec Spec) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Minute)
+// Print writes the plan grouped by manager. Skipped and not applicable
+// entries are only counted unless verbose is set.
+func (p *Plan) Print(w io.Writer, verbose bool) {
+	for _, m := range p.Managers {
+		skipped, notApplicable := 0, 0
+		var lines []string
+		for _, c := range m.Changes {
+			if !verbose && c.Action == installer.ActionSkip {
+				skipped++
+				continue
+			}
+			if !verbose && c.Action == installer.ActionNotApplicable {
+				notApplicable++
+				continue
+			}
+			lines = append(lines, formatChange(c, verbose)...)
+		}
+		var counts []string
+		if skipped > 0 {
+			counts = append(counts, fmt.Sprintf("%d up to date", skipped))
+		}
+		if notApplicable > 0 {
+			counts = append(counts, fmt.Sprintf("%d not applicable", notApplicable))
+		}
+		header := m.Manager
+		if len(counts) > 0 {
+			header += " (" + strings.Join(counts, ", ") + ")"
+		}
+		fmt.Fprintln(w, header)
+		for _, l := range lines {
+			fmt.Fprintln(w, l)
+		}
+	}
+}
+
+func formatChange(c installer.Change, verbose bool) []string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "  %s %-9s %s", actionIcons[c.Action], c.Action, c.Name)
+	switch {
+	case c.From != "" && c.To != "" && c.From != c.To:
+		fmt.Fprintf(&b, " %s -> %s", c.From, c.To)
+	case c.To != "":
+		fmt.Fprintf(&b, " %s", c.To)
+	case c.From != "":
+		fmt.Fprintf(&b, " %s", c.From)
+	}
+	if c.Reason != "" {
+		fmt.Fprintf(&b, " (%s)", c.Reason)
+	}
+	lines := []string{b.String()}
+	if verbose {
+		for _, d := range c.Details {
+			lines = append(lines, "      "+d)
+		}
+	}
+	return lines
+}
+
+func runManager(ctx context.Context, m ManagerPlan) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+	if m.installer == nil || len(installer.Pending(m.Changes)) == 0 {
+		return nil
+	}
+	if err := m.installer.Apply(ctx, m.Changes); err != nil {
+		return fmt.Errorf("%s: %w", m.Manager, err)
+	}
+	return nil
+}
+
+// Apply executes the plan. The native package manager runs first since
+// other managers may need its packages, recipes run last, and everything in
+// between runs in parallel. Nothing is executed when the context carries
+// a dry run.
+func Apply(ctx context.Context, plan *Plan) error {
+	if utils.GetExecOptions(ctx).DryRun {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Minute)
 	defer cancel()
 
+	var first, parallel, last []ManagerPlan
+	for _, m := range plan.Managers {
+		switch m.Manager {
+		case string(internal.PackageTypeApt):
+			first = append(first, m)
+		case string(internal.PackageTypeRecipe):
+			last = append(last, m)
+		default:
+			parallel = append(parallel, m)
+		}
+	}
+
+	for _, m := range first {
+		if err := runManager(ctx, m); err != nil {
+			return err
+		}
+	}
+
 	var wg sync.WaitGroup
-	tasks := []func() error{
-		func() error { return runBrew(ctx, spec.BrewFormulas) },
-		func() error { return runFlatpak(ctx, spec.Flatpaks) },
+	tasks := make([]func() error, 0, len(parallel))
+	for _, m := range parallel {
+		tasks = append(tasks, func() error { return runManager(ctx, m) })
 	}
 	errs := make(chan error, len(tasks))
 
@@ -89,5 +346,11 @@ func InstallAll(spec Spec) error {
 	if len(failed) > 0 {
 		return fmt.Errorf("some installers failed:\n- %s", strings.Join(failed, "\n- "))
 	}
+
+	for _, m := range last {
+		if err := runManager(ctx, m); err != nil {
+			return err
+		}
+	}
 	return nil
 }
@@ -0,0 +1,84 @@
+package install
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	"github.com/aottr/sth/internal"
+	"github.com/aottr/sth/internal/brew"
+	"github.com/aottr/sth/internal/diff"
+	"github.com/aottr/sth/internal/installer"
+)
+
+// Live returns what is installed on this machine as diff entries. For
+// installers that can tell packages installed on request from dependencies,
+// only those and the packages declared in pkgs are included.
+func Live(ctx context.Context, pkgs *internal.Packages, installers []installer.Installer) (diff.Entries, error) {
+	declared := diff.FromPackages(pkgs)
+	live := diff.Entries{}
+	for _, inst := range installers {
+		if !inst.Detect() {
+			continue
+		}
+		name := inst.Name()
+		installed, err := inst.Installed(ctx)
+		if name == string(internal.PackageTypeBrew) {
+			// casks are compared under their own source
+			installed, err = brew.InstalledFormulae(ctx)
+			if err == nil {
+				err = liveCasks(ctx, declared, live)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+
+		// declared names by their last path element, e.g. tapped brew formulae
+		byBase := make(map[string]string, len(declared[name]))
+		for n := range declared[name] {
+			byBase[path.Base(n)] = n
+		}
+
+		var keep map[string]struct{}
+		if pruner, ok := inst.(installer.Pruner); ok {
+			drift, err := pruner.Drift(ctx, pkgs)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			keep = make(map[string]struct{}, len(drift))
+			for _, c := range drift {
+				keep[c.Name] = struct{}{}
+			}
+		}
+
+		for _, p := range installed {
+			n := p.Name
+			if _, ok := declared[name][n]; !ok {
+				if full, ok := byBase[n]; ok {
+					n = full
+				} else if keep != nil {
+					if _, ok := keep[n]; !ok {
+						continue
+					}
+				}
+			}
+			live.Set(name, n, p.Version)
+		}
+	}
+	return live, nil
+}
+
+// liveCasks adds the installed casks that are declared
+func liveCasks(ctx context.Context, declared, live diff.Entries) error {
+	casks, err := brew.InstalledCasks(ctx)
+	if err != nil {
+		return err
+	}
+	for _, c := range casks {
+		if _, ok := declared[diff.SourceBrewCask][c.Name]; ok {
+			live.Set(diff.SourceBrewCask, c.Name, c.Version)
+		}
+	}
+	return nil
+}
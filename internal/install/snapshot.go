@@ -0,0 +1,69 @@
+package install
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aottr/sth/internal"
+	"github.com/aottr/sth/internal/brew"
+	"github.com/aottr/sth/internal/flatpak"
+	"github.com/aottr/sth/internal/native"
+	"github.com/aottr/sth/internal/platform"
+	"github.com/aottr/sth/internal/sthpkgs"
+	"github.com/aottr/sth/internal/utils"
+)
+
+// Snapshot builds a packages config from what is installed on request on
+// this machine: manual apt packages, flatpak apps, brew leaves and sth
+// artifacts. Managers that are not available are left out.
+func Snapshot(ctx context.Context, name string) (*internal.Packages, error) {
+	pi := platform.GetPlatformInfo()
+	pkgs := &internal.Packages{
+		Name:     utils.StringPtr(name),
+		Platform: pi,
+	}
+
+	if _, err := native.GetDriverForRelease(pi.Family); err == nil {
+		apt, err := native.ExportPackages(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("apt: %w", err)
+		}
+		pkgs.Apt = apt
+	}
+
+	if flatpak.New().Detect() {
+		refs, err := flatpak.Installed(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("flatpak: %w", err)
+		}
+		for _, r := range refs {
+			ref := internal.FlatpakRef{ID: r.ID, User: r.Installation == flatpak.InstallationUser}
+			if r.Origin != flatpak.DefaultRemote {
+				ref.Remote = r.Origin
+			}
+			if r.Branch != "stable" {
+				ref.Branch = r.Branch
+			}
+			pkgs.Flatpak = append(pkgs.Flatpak, ref)
+		}
+	}
+
+	if brew.New().Detect() {
+		leaves, err := brew.Leaves(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("brew: %w", err)
+		}
+		for _, l := range leaves {
+			pkgs.Brew.Formulae = append(pkgs.Brew.Formulae, internal.BrewFormula{Name: l})
+		}
+	}
+
+	manifests, err := sthpkgs.ListManifests(sthpkgs.DefaultPaths(sthpkgs.InstallScopeUser))
+	if err != nil {
+		return nil, fmt.Errorf("recipes: %w", err)
+	}
+	for _, m := range manifests {
+		pkgs.Recipes = append(pkgs.Recipes, m.Key())
+	}
+	return pkgs, nil
+}
@@ -0,0 +1,165 @@
+package install
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/aottr/sth/internal"
+	"github.com/aottr/sth/internal/diff"
+	"github.com/aottr/sth/internal/installer"
+	"github.com/aottr/sth/internal/recipes"
+)
+
+type State string
+
+const (
+	StateInstalled   State = "installed"
+	StateMissing     State = "missing"
+	StateOutdated    State = "outdated"
+	StateHeld        State = "held"
+	StateUnavailable State = "unavailable"
+	// StateNotApplicable marks entries whose when: does not hold here
+	StateNotApplicable State = "not applicable"
+)
+
+// Entry is the state of one declared package
+type Entry struct {
+	Manager   string `json:"manager"`
+	Name      string `json:"name"`
+	State     State  `json:"state"`
+	Installed string `json:"installed,omitempty"`
+	Wanted    string `json:"wanted,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// Report is the state of every package declared in packages.yml
+type Report struct {
+	// Merged is what packages.yml was merged from, see internal.Packages
+	Merged  []string `json:"merged"`
+	Entries []Entry  `json:"entries"`
+}
+
+// Status checks every declared package against the machine. It plans like
+// install does, but recipes are checked offline against their manifests.
+func Status(ctx context.Context, pkgs *internal.Packages, installers []installer.Installer) (*Report, error) {
+	for _, inst := range installers {
+		if r, ok := inst.(*recipes.Driver); ok {
+			r.Offline = true
+		}
+	}
+	plan, err := MakePlan(ctx, pkgs, installers)
+	if err != nil {
+		return nil, err
+	}
+
+	declared := diff.FromPackages(pkgs)
+	report := &Report{Merged: pkgs.Merged, Entries: []Entry{}}
+	for _, m := range plan.Managers {
+		available := m.installer != nil && m.installer.Detect()
+		seen := make(map[string]struct{})
+		for _, c := range m.Changes {
+			// repositories, remotes, overrides and removals are not packages
+			if _, ok := declared[m.Manager][c.Name]; !ok {
+				continue
+			}
+			if _, ok := seen[c.Name]; ok || c.Action == installer.ActionConfigure || c.Action == installer.ActionRemove {
+				continue
+			}
+			seen[c.Name] = struct{}{}
+
+			e := Entry{Manager: m.Manager, Name: c.Name, Installed: c.From, Wanted: c.To, Reason: c.Reason}
+			switch {
+			case !available:
+				e.State = StateUnavailable
+			case c.Action == installer.ActionInstall && c.From == "":
+				e.State = StateMissing
+			case c.Action == installer.ActionInstall, c.Action == installer.ActionUpgrade:
+				e.State = StateOutdated
+			case c.Action == installer.ActionHold:
+				e.State = StateHeld
+			default:
+				e.State = StateInstalled
+			}
+			report.Entries = append(report.Entries, e)
+		}
+	}
+	for _, s := range pkgs.Skipped {
+		report.Entries = append(report.Entries, Entry{Manager: s.Manager, Name: s.Name, State: StateNotApplicable, Reason: s.Reason})
+	}
+	sort.SliceStable(report.Entries, func(i, j int) bool {
+		return managerIndex(report.Entries[i].Manager) < managerIndex(report.Entries[j].Manager)
+	})
+	return report, nil
+}
+
+// Compliant reports whether every declared package is installed as wanted.
+// Held packages count as installed, entries for other platforms are ignored.
+func (r *Report) Compliant() bool {
+	return r.Failing() == 0
+}
+
+// Failing returns the number of packages that are missing, outdated or
+// whose manager is not available
+func (r *Report) Failing() int {
+	n := 0
+	for _, e := range r.Entries {
+		if e.State != StateInstalled && e.State != StateHeld && e.State != StateNotApplicable {
+			n++
+		}
+	}
+	return n
+}
+
+var stateIcons = map[State]string{
+	StateInstalled:     "✅",
+	StateMissing:       "❌",
+	StateOutdated:      "⚠️",
+	StateHeld:          "⛔",
+	StateUnavailable:   "🚫",
+	StateNotApplicable: "➖",
+}
+
+// Print writes the report grouped by manager
+func (r *Report) Print(w io.Writer) {
+	if len(r.Merged) > 0 {
+		fmt.Fprintf(w, "📚 packages.yml: %s\n", strings.Join(r.Merged, " + "))
+	}
+	manager := ""
+	for _, e := range r.Entries {
+		if e.Manager != manager {
+			manager = e.Manager
+			fmt.Fprintln(w, manager)
+		}
+		line := fmt.Sprintf("  %s %-14s %s", stateIcons[e.State], e.State, e.Name)
+		switch {
+		case e.State == StateOutdated && e.Wanted != "":
+			line += fmt.Sprintf(" %s (wants %s)", e.Installed, e.Wanted)
+		case e.Installed != "":
+			line += " " + e.Installed
+		}
+		if e.Reason != "" && e.State != StateInstalled {
+			line += " (" + e.Reason + ")"
+		}
+		fmt.Fprintln(w, line)
+	}
+}
+
+// WriteJSON writes the report as JSON
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// managerIndex orders report entries like diff.Sources
+func managerIndex(manager string) int {
+	if i := slices.Index(diff.Sources, manager); i >= 0 {
+		return i
+	}
+	return len(diff.Sources)
+}
@@ -0,0 +1,186 @@
+package installer
+
+import (
+	"context"
+	"strings"
+
+	"github.com/aottr/sth/internal"
+	"github.com/aottr/sth/internal/utils"
+)
+
+type Action string
+
+const (
+	ActionInstall   Action = "install"
+	ActionUpgrade   Action = "upgrade"   // installed, but not the wanted version
+	ActionRemove    Action = "remove"    // installed, but no longer declared
+	ActionConfigure Action = "configure" // remotes, repositories, overrides
+	ActionHold      Action = "hold"      // installed and held back by the package manager
+	ActionSkip      Action = "skip"      // nothing to do
+
+	// ActionNotApplicable marks entries that cannot be done here: left out
+	// by their when: condition, no tool backend applies or the backend is
+	// not available
+	ActionNotApplicable Action = "n/a"
+)
+
+// Change is one planned step of an installer. Spec carries the backend
+// specific entry needed to apply it and is never serialized.
+type Change struct {
+	Manager string   `json:"manager"`
+	Action  Action   `json:"action"`
+	Name    string   `json:"name"`
+	From    string   `json:"from,omitempty"` // installed version
+	To      string   `json:"to,omitempty"`   // wanted version
+	Reason  string   `json:"reason,omitempty"`
+	Details []string `json:"details,omitempty"`
+
+	Spec any `json:"-"`
+}
+
+// Package is an installed package as reported by a backend
+type Package struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+// Installer is implemented by every package source sth can install from.
+//
+// Plan compares the desired packages.yml state with the machine and must not
+// change anything; Apply executes (a subset of) the changes Plan returned.
+type Installer interface {
+	// Name is the packages.yml section the installer handles, e.g. "apt"
+	Name() string
+	// Detect reports whether the backend's tooling is available
+	Detect() bool
+	Installed(ctx context.Context) ([]Package, error)
+	Plan(ctx context.Context, desired *internal.Packages) ([]Change, error)
+	Apply(ctx context.Context, plan []Change) error
+}
+
+// Pruner is implemented by installers that can tell which packages were
+// installed on request rather than as dependencies. Drift plans the removal
+// of those that desired does not declare.
+type Pruner interface {
+	Drift(ctx context.Context, desired *internal.Packages) ([]Change, error)
+}
+
+// Remover is implemented by installers whose removals take other packages
+// along. Removals returns plan with those added, so they can be confirmed.
+type Remover interface {
+	Removals(ctx context.Context, plan []Change) ([]Change, error)
+}
+
+// SkipAll marks every name as not applicable, e.g. when the backend is not
+// available
+func SkipAll(manager string, names []string, reason string) []Change {
+	changes := make([]Change, 0, len(names))
+	for _, n := range names {
+		changes = append(changes, Change{Manager: manager, Action: ActionNotApplicable, Name: n, Reason: reason})
+	}
+	return changes
+}
+
+// Pending returns the changes that do something
+func Pending(plan []Change) []Change {
+	var out []Change
+	for _, c := range plan {
+		if c.Action != ActionSkip && c.Action != ActionHold && c.Action != ActionNotApplicable {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// ByAction returns the changes with one of the given actions
+func ByAction(plan []Change, actions ...Action) []Change {
+	var out []Change
+	for _, c := range plan {
+		for _, a := range actions {
+			if c.Action == a {
+				out = append(out, c)
+				break
+			}
+		}
+	}
+	return out
+}
+
+// Names returns the names of changes
+func Names(plan []Change) []string {
+	names := make([]string, 0, len(plan))
+	for _, c := range plan {
+		names = append(names, c.Name)
+	}
+	return names
+}
+
+//// VERSION CONSTRAINTS ////
+
+type Op string
+
+const (
+	OpEq   Op = "="
+	OpGe   Op = ">="
+	OpNone Op = "" // treat as exact upstream equality
+)
+
+type VersionConstraint struct {
+	Op    Op
+	Value string // user-specified version (may be upstream-only)
+}
+
+func ParseConstraint(s string) VersionConstraint {
+	s = strings.TrimSpace(s)
+	switch {
+	case strings.HasPrefix(s, ">="):
+		return VersionConstraint{Op: OpGe, Value: strings.TrimSpace(s[2:])}
+	case strings.HasPrefix(s, "="):
+		return VersionConstraint{Op: OpEq, Value: strings.TrimSpace(s[1:])}
+	default:
+		// bare value, treat as exact upstream equality
+		return VersionConstraint{Op: OpNone, Value: s}
+	}
+}
+
+// IsLatest reports whether the constraint accepts any version
+func (c VersionConstraint) IsLatest() bool {
+	return c.Value == "" || strings.EqualFold(c.Value, "latest") || c.Value == "*"
+}
+
+// PlanVersions plans name -> version pins against installed versions, as
+// used by the language package managers. Pins are compared with
+// utils.VersionSatisfies.
+func PlanVersions(manager string, desired, installed map[string]string) []Change {
+	changes := make([]Change, 0, len(desired))
+	for _, name := range utils.SortedKeys(desired) {
+		want := desired[name]
+		c := Change{Manager: manager, Name: name}
+		if utils.IsPinned(want) {
+			c.To = want
+		}
+		cur, ok := installed[name]
+		switch {
+		case !ok:
+			c.Action = ActionInstall
+		case utils.VersionSatisfies(cur, want):
+			c.Action = ActionSkip
+			c.From = cur
+			c.Reason = "installed"
+		default:
+			c.Action = ActionUpgrade
+			c.From = cur
+		}
+		changes = append(changes, c)
+	}
+	return changes
+}
+
+// Packages converts a name -> version map into a sorted package list
+func Packages(m map[string]string) []Package {
+	pkgs := make([]Package, 0, len(m))
+	for _, name := range utils.SortedKeys(m) {
+		pkgs = append(pkgs, Package{Name: name, Version: m[name]})
+	}
+	return pkgs
+}
@@ -1,119 +1,381 @@
 package apt
 
 import (
+	"context"
 	"fmt"
+	"os"
 	"os/exec"
+	"sort"
 	"strings"
+	"time"
 
-	// installer "github.com/aottr/sth/internal/drivers"
+	"github.com/aottr/sth/internal"
+	"github.com/aottr/sth/internal/installer"
 	"github.com/aottr/sth/internal/utils"
 )
 
+// DefaultUpdateTTL is how old the apt lists may be before `apt update` runs again.
+const DefaultUpdateTTL = 6 * time.Hour
+
+// updateStamps are touched by a successful `apt update`, in order of preference:
+// the stamp of APT::Update::Post-Invoke-Success and the binary cache apt
+// rebuilds after reading the lists
+var updateStamps = []string{"/var/lib/apt/periodic/update-success-stamp", "/var/cache/apt/pkgcache.bin"}
+
 type DebianDriver struct {
-	Packages map[string]string
+	Packages     map[string]string
+	Repositories []internal.AptRepository
+
+	// UpdateTTL skips `apt update` when the package lists are younger than this.
+	// Zero always updates.
+	UpdateTTL time.Duration
+
+	// Purge also removes the configuration files of removed packages
+	Purge bool
+
+	// set when sources changed and the lists must be refreshed regardless of UpdateTTL
+	listsStale bool
 }
 
 func New(packages map[string]string) *DebianDriver {
 	return &DebianDriver{
-		Packages: packages,
+		Packages:  packages,
+		UpdateTTL: DefaultUpdateTTL,
 	}
 }
 
-func (d *DebianDriver) InstallAll() error {
-	if len(d.Packages) == 0 {
-		return nil
+func (d *DebianDriver) Name() string { return string(internal.PackageTypeApt) }
+
+func (d *DebianDriver) Detect() bool {
+	_, errApt := exec.LookPath("apt-get")
+	_, errDpkg := exec.LookPath("dpkg-query")
+	return errApt == nil && errDpkg == nil
+}
+
+// Installed lists all fully installed packages
+func (d *DebianDriver) Installed(ctx context.Context) ([]installer.Package, error) {
+	cmd := exec.CommandContext(ctx, "dpkg-query", "-W", "-f", "${Package}\t${db:Status-Abbrev}\t${Version}\n")
+	b, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("dpkg-query failed: %w", err)
 	}
-	fmt.Println("🔄 Running apt update")
-	if _, err := utils.RunCommand("sudo", "apt", "update"); err != nil {
+	var pkgs []installer.Package
+	for name, st := range parseStates(string(b)) {
+		pkgs = append(pkgs, installer.Package{Name: name, Version: st.Version})
+	}
+	sort.Slice(pkgs, func(i, j int) bool { return pkgs[i].Name < pkgs[j].Name })
+	return pkgs, nil
+}
+
+// Plan compares the apt section and aptRepositories with dpkg's state
+func (d *DebianDriver) Plan(ctx context.Context, desired *internal.Packages) ([]installer.Change, error) {
+	d.Packages = desired.Apt
+	d.Repositories = desired.AptRepositories
+
+	names := utils.SortedKeys(d.Packages)
+	if !d.Detect() {
+		return installer.SkipAll(d.Name(), names, "apt not available"), nil
+	}
+
+	changes := planRepositories(d.Name(), d.Repositories)
+	states, err := InstalledStates(names)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		want := d.Packages[name]
+		c := installer.ParseConstraint(want)
+		change := installer.Change{Manager: d.Name(), Name: name, Spec: c}
+		if !c.IsLatest() {
+			change.To = c.Value
+		}
+		st, ok := states[baseName(name)]
+		switch {
+		case !ok:
+			change.Action = installer.ActionInstall
+		case st.Held:
+			change.Action = installer.ActionHold
+			change.From = st.Version
+			change.Reason = "held by apt-mark"
+		case c.IsLatest():
+			change.Action = installer.ActionSkip
+			change.From = st.Version
+			change.Reason = "installed"
+		default:
+			change.From = st.Version
+			ok, err := satisfiesConstraint(st.Version, c)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				change.Action = installer.ActionSkip
+				change.Reason = "installed"
+			} else {
+				change.Action = installer.ActionUpgrade
+			}
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+// Apply syncs repositories, installs and upgrades in a single apt transaction,
+// holds pinned packages and removes packages planned for removal.
+func (d *DebianDriver) Apply(ctx context.Context, plan []installer.Change) error {
+	changed, err := SyncRepositories(d.Repositories)
+	if err != nil {
 		return err
 	}
-	for pkg := range d.Packages {
+	d.listsStale = d.listsStale || changed
 
-		if err := ensureLatest(pkg); err != nil {
+	install := installer.ByAction(plan, installer.ActionInstall, installer.ActionUpgrade)
+	if len(install) > 0 {
+		if err := d.install(install); err != nil {
 			return err
 		}
-		// c := installer.ParseConstraint(version)
-		// switch {
-		// case strings.EqualFold(version, "latest"):
-		// 	if err := ensureLatest(pkg); err != nil {
-		// 		return err
-		// 	}
-		// default:
-		// figure out how to get apt versions to follow upstream...
-		// if err := ensureLatest(pkg); err != nil {
-		// 	return err
-		// }
-		// if err := ensureVersion(pkg, c); err != nil {
-		// 	return err
-		// }
-		//}
+		for _, c := range install {
+			if err := ensurePinned(c); err != nil {
+				return err
+			}
+		}
 	}
-	return nil
+
+	var remove []string
+	for _, c := range installer.ByAction(plan, installer.ActionRemove) {
+		// stale repositories were removed by SyncRepositories
+		if _, ok := c.Spec.(internal.AptRepository); !ok {
+			remove = append(remove, c.Name)
+		}
+	}
+	return d.remove(ctx, remove)
 }
 
-func (d *DebianDriver) Install(pkgs []string) error {
-	if _, err := utils.RunCommand("sudo", "apt", "update"); err != nil {
+// install installs the planned packages in a single apt transaction, exact
+// pins as name=version. Names apt does not know about are reported before
+// anything is installed.
+func (d *DebianDriver) install(changes []installer.Change) error {
+	if err := d.update(); err != nil {
+		return err
+	}
+
+	pkgs := installer.Names(changes)
+	unknown, err := UnknownPackages(pkgs)
+	if err != nil {
 		return err
 	}
-	for _, pkg := range pkgs {
-		if err := ensureLatest(pkg); err != nil {
+	if len(unknown) > 0 {
+		return fmt.Errorf("unknown apt packages: %s", strings.Join(unknown, ", "))
+	}
+
+	args := []string{"apt-get", "install", "-y"}
+	pinned := false
+	for i, c := range changes {
+		vc, ok := c.Spec.(installer.VersionConstraint)
+		if !ok || vc.IsLatest() || vc.Op == installer.OpGe || strings.Contains(c.Name, "=") {
+			continue
+		}
+		ver, err := availableVersion(baseName(c.Name), vc.Value)
+		if err != nil {
 			return err
 		}
+		pkgs[i] = c.Name + "=" + ver
+		pinned = true
+	}
+	if pinned {
+		// a pin below the installed version is a downgrade
+		args = append(args, "--allow-downgrades")
+	}
+
+	fmt.Printf("📦 Installing %d apt packages: %s\n", len(pkgs), strings.Join(pkgs, " "))
+	args = append(args, pkgs...)
+	if _, err := utils.RunCommand("sudo", args...); err != nil {
+		return err
 	}
 	return nil
 }
 
-func ensureLatest(pkg string) error {
-	if IsInstalled(pkg) {
-		fmt.Println("🔄 Skipping already installed apt package: ", pkg)
+// availableVersion returns the full version apt can install for an exact pin,
+// which may give only the upstream version, e.g. "2.43.0" for "1:2.43.0-1"
+func availableVersion(pkg, want string) (string, error) {
+	out, err := exec.Command("apt-cache", "madison", pkg).Output()
+	if err != nil {
+		return "", fmt.Errorf("apt-cache madison %s failed: %w", pkg, err)
+	}
+	// "      git | 1:2.43.0-1ubuntu7.3 | http://archive.ubuntu.com/ubuntu noble-updates/main amd64 Packages",
+	// newest first
+	var available []string
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Split(line, "|")
+		if len(fields) < 2 {
+			continue
+		}
+		v := strings.TrimSpace(fields[1])
+		if v == want {
+			return v, nil
+		}
+		if ok, err := dpkgCompare(extractUpstream(v), "eq", want); err == nil && ok {
+			return v, nil
+		}
+		available = append(available, v)
+	}
+	if len(available) == 0 {
+		return "", fmt.Errorf("%s %s is not available", pkg, want)
+	}
+	return "", fmt.Errorf("%s %s is not available, apt has %s", pkg, want, strings.Join(available, ", "))
+}
+
+// ensurePinned verifies an exact version pin after installing and holds the
+// package so that upgrades do not move it
+func ensurePinned(c installer.Change) error {
+	vc, ok := c.Spec.(installer.VersionConstraint)
+	if !ok || vc.IsLatest() {
 		return nil
 	}
-	fmt.Printf("📦 Installing latest apt package: %s\n", pkg)
-	if _, err := utils.RunCommand("sudo", "apt", "install", "-y", pkg); err != nil {
+	ver, err := getInstalledVersion(baseName(c.Name))
+	if err != nil {
+		return err
+	}
+	ok, err = satisfiesConstraint(ver, vc)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("after install, %s version %q does not satisfy %s %s", c.Name, ver, string(vc.Op), vc.Value)
+	}
+	if vc.Op != installer.OpGe {
+		fmt.Printf("⛔ Holding apt package: %s\n", c.Name)
+		if _, err := utils.RunCommand("sudo", "apt-mark", "hold", baseName(c.Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// update runs `apt update` unless the lists are fresher than UpdateTTL.
+func (d *DebianDriver) update() error {
+	if d.UpdateTTL > 0 && !d.listsStale {
+		if age, ok := listsAge(); ok && age < d.UpdateTTL {
+			fmt.Printf("🔄 Skipping apt update; lists are %s old\n", age.Round(time.Minute))
+			return nil
+		}
+	}
+	fmt.Println("🔄 Running apt update")
+	if _, err := utils.RunCommand("sudo", "apt", "update"); err != nil {
 		return err
 	}
+	d.listsStale = false
 	return nil
 }
 
-// func ensureVersion(pkg string, c installer.VersionConstraint) error {
-// 	// chek if need to install
-// 	ver, err := getInstalledVersion(pkg)
-// 	if err != nil {
-// 		return err
-// 	}
-// 	installed, err := satisfiesConstraint(ver, c)
-// 	if err != nil {
-// 		return err
-// 	}
-// 	if installed {
-// 		fmt.Printf("🔄 Skipping apt package %s; installed %q satisfies %s %s\n", pkg, ver, string(c.Op), c.Value)
-// 		return nil
-// 	}
-
-// 	// installing requested version
-// 	fmt.Printf("📦 Installing/Upgrading apt package to satisfy %s %s: %s\n", string(c.Op), c.Value, pkg)
-// 	if _, err := utils.RunCommand("sudo", "apt", "install", "-y", pkg); err != nil {
-// 		return err
-// 	}
-// 	// Re-check
-// 	ver, _ = getInstalledVersion(pkg)
-// 	ok, err := satisfiesConstraint(ver, c)
-// 	if err != nil {
-// 		return err
-// 	}
-// 	if !ok {
-// 		return fmt.Errorf("after install, %s version %q does not satisfy %s %s", pkg, ver, string(c.Op), c.Value)
-// 	}
-
-// 	// mark package as held if version is pinned
-// 	if c.Op != installer.OpGe {
-// 		fmt.Printf("⛔ Holding apt package: %s\n", pkg)
-// 		if _, err := utils.RunCommand("sudo", "apt-mark", "hold", pkg); err != nil {
-// 			return err
-// 		}
-// 	}
-// 	return nil
-// }
+// listsAge returns the time since the last successful `apt update`. The list
+// files themselves carry the server's Last-Modified time, so the stamps apt
+// writes after updating are used instead.
+func listsAge() (time.Duration, bool) {
+	for _, stamp := range updateStamps {
+		if fi, err := os.Stat(stamp); err == nil {
+			return time.Since(fi.ModTime()), true
+		}
+	}
+	return 0, false
+}
+
+// PackageState is the dpkg state of an installed package
+type PackageState struct {
+	Version string
+	Held    bool
+}
+
+// InstalledStates returns the state of each of pkgs that is fully installed,
+// keyed by package name, using a single dpkg-query call.
+func InstalledStates(pkgs []string) (map[string]PackageState, error) {
+	if len(pkgs) == 0 {
+		return map[string]PackageState{}, nil
+	}
+	names := make([]string, 0, len(pkgs))
+	for _, p := range pkgs {
+		names = append(names, baseName(p))
+	}
+	args := append([]string{"-W", "-f", "${Package}\t${db:Status-Abbrev}\t${Version}\n"}, names...)
+	cmd := exec.Command("dpkg-query", args...)
+	b, err := cmd.Output()
+	if err != nil {
+		// exit code 1 only means some packages are unknown to dpkg
+		if ee, ok := err.(*exec.ExitError); !ok || ee.ExitCode() != 1 {
+			return nil, fmt.Errorf("dpkg-query failed: %w", err)
+		}
+	}
+	return parseStates(string(b)), nil
+}
+
+// parseStates reads "<package>\t<status abbrev>\t<version>" lines
+func parseStates(out string) map[string]PackageState {
+	states := make(map[string]PackageState)
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(fields[1], "ii"):
+			states[fields[0]] = PackageState{Version: strings.TrimSpace(fields[2])}
+		case strings.HasPrefix(fields[1], "hi"):
+			states[fields[0]] = PackageState{Version: strings.TrimSpace(fields[2]), Held: true}
+		}
+	}
+	return states
+}
+
+// UnknownPackages returns the names in pkgs that have no install candidate.
+func UnknownPackages(pkgs []string) ([]string, error) {
+	if len(pkgs) == 0 {
+		return nil, nil
+	}
+	names := make([]string, 0, len(pkgs))
+	for _, p := range pkgs {
+		names = append(names, baseName(p))
+	}
+	cmd := exec.Command("apt-cache", append([]string{"policy"}, names...)...)
+	b, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("apt-cache policy failed: %w", err)
+	}
+
+	// apt-cache policy prints "<name>:" followed by indented details and omits
+	// names it does not know at all
+	known := make(map[string]bool, len(names))
+	current := ""
+	for _, line := range strings.Split(string(b), "\n") {
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, " ") {
+			current = strings.TrimSuffix(strings.TrimSpace(line), ":")
+			continue
+		}
+		if cand, ok := strings.CutPrefix(strings.TrimSpace(line), "Candidate:"); ok && current != "" {
+			known[current] = strings.TrimSpace(cand) != "(none)"
+		}
+	}
+	var unknown []string
+	for i, n := range names {
+		if !known[n] {
+			unknown = append(unknown, pkgs[i])
+		}
+	}
+	return unknown, nil
+}
+
+// baseName strips version pins and architecture qualifiers,
+// e.g. "git=1:2.43.0-1" or "libc6:amd64"
+func baseName(pkg string) string {
+	if i := strings.IndexByte(pkg, '='); i != -1 {
+		pkg = pkg[:i]
+	}
+	if i := strings.IndexByte(pkg, ':'); i != -1 {
+		pkg = pkg[:i]
+	}
+	return strings.TrimSpace(pkg)
+}
 
 func IsInstalled(pkg string) bool {
 	cmd := exec.Command("dpkg", "-s", pkg)
@@ -187,21 +449,21 @@ func extractUpstream(v string) string {
 	return upstream
 }
 
-// func satisfiesConstraint(installedVersion string, c installer.VersionConstraint) (bool, error) {
-// 	if installedVersion == "" {
-// 		return false, nil
-// 	}
-// 	wantedVersion := strings.TrimSpace(c.Value)
-// 	if wantedVersion == "" {
-// 		return false, nil
-// 	}
-// 	upstreamVersion := extractUpstream(installedVersion)
-// 	switch c.Op {
-// 	case installer.OpEq, installer.OpNone:
-// 		return dpkgCompare(upstreamVersion, "eq", wantedVersion)
-// 	case installer.OpGe:
-// 		return dpkgCompare(upstreamVersion, "ge", wantedVersion)
-// 	default:
-// 		return false, fmt.Errorf("unsupported operator: %q", c.Op)
-// 	}
-// }
+func satisfiesConstraint(installedVersion string, c installer.VersionConstraint) (bool, error) {
+	if installedVersion == "" {
+		return false, nil
+	}
+	wantedVersion := strings.TrimSpace(c.Value)
+	if wantedVersion == "" {
+		return false, nil
+	}
+	upstreamVersion := extractUpstream(installedVersion)
+	switch c.Op {
+	case installer.OpEq, installer.OpNone:
+		return dpkgCompare(upstreamVersion, "eq", wantedVersion)
+	case installer.OpGe:
+		return dpkgCompare(upstreamVersion, "ge", wantedVersion)
+	default:
+		return false, fmt.Errorf("unsupported operator: %q", c.Op)
+	}
+}
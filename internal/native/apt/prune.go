@@ -0,0 +1,248 @@
+package apt
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/aottr/sth/internal"
+	"github.com/aottr/sth/internal/installer"
+	"github.com/aottr/sth/internal/utils"
+)
+
+// ManualPackages lists packages marked as manually installed, leaving out
+// essential and required/important packages which belong to the base system.
+func ManualPackages(ctx context.Context) ([]string, error) {
+	out, err := exec.CommandContext(ctx, "apt-mark", "showmanual").Output()
+	if err != nil {
+		return nil, fmt.Errorf("apt-mark showmanual failed: %w", err)
+	}
+	manual := strings.Fields(string(out))
+	if len(manual) == 0 {
+		return nil, nil
+	}
+
+	args := append([]string{"-W", "-f", "${Package}\t${Essential}\t${Priority}\n"}, manual...)
+	out, err = exec.CommandContext(ctx, "dpkg-query", args...).Output()
+	if err != nil {
+		if ee, ok := err.(*exec.ExitError); !ok || ee.ExitCode() != 1 {
+			return nil, fmt.Errorf("dpkg-query failed: %w", err)
+		}
+	}
+	base := make(map[string]struct{})
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 {
+			continue
+		}
+		if fields[1] == "yes" || fields[2] == "required" || fields[2] == "important" {
+			base[fields[0]] = struct{}{}
+		}
+	}
+
+	var names []string
+	for _, name := range manual {
+		if _, ok := base[baseName(name)]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Drift plans the removal of manually installed packages that are not in
+// the apt section and of the packages nothing needs anymore, together with
+// the packages apt would take along
+func (d *DebianDriver) Drift(ctx context.Context, desired *internal.Packages) ([]installer.Change, error) {
+	d.Packages = desired.Apt
+	d.Repositories = desired.AptRepositories
+	if !d.Detect() {
+		return nil, nil
+	}
+	manual, err := ManualPackages(ctx)
+	if err != nil {
+		return nil, err
+	}
+	orphans, err := autoremovable(ctx)
+	if err != nil {
+		return nil, err
+	}
+	declared := d.declared()
+	var changes []installer.Change
+	seen := make(map[string]struct{})
+	add := func(name, reason string) {
+		if _, ok := declared[baseName(name)]; ok {
+			return
+		}
+		if _, ok := seen[baseName(name)]; ok {
+			return
+		}
+		seen[baseName(name)] = struct{}{}
+		changes = append(changes, installer.Change{Manager: d.Name(), Action: installer.ActionRemove, Name: name, Reason: reason})
+	}
+	for _, name := range manual {
+		add(name, "not declared")
+	}
+	for _, name := range orphans {
+		add(name, "no longer needed")
+	}
+	return d.Removals(ctx, changes)
+}
+
+// Removals adds the reverse dependencies apt removes along with the planned
+// removals. A removal that would take a declared package along is planned
+// as a skip instead.
+func (d *DebianDriver) Removals(ctx context.Context, changes []installer.Change) ([]installer.Change, error) {
+	planned := make(map[string]int)
+	var names []string
+	for i, c := range changes {
+		if c.Action != installer.ActionRemove {
+			continue
+		}
+		if _, ok := c.Spec.(internal.AptRepository); ok {
+			continue
+		}
+		planned[baseName(c.Name)] = i
+		names = append(names, baseName(c.Name))
+	}
+	if len(names) == 0 {
+		return changes, nil
+	}
+	declared := d.declared()
+	ok, blocked, gone, err := splitRemovals(ctx, names, func(name string) bool {
+		_, isDeclared := declared[name]
+		_, isPlanned := planned[name]
+		return isDeclared && !isPlanned
+	})
+	if err != nil {
+		return nil, err
+	}
+	for name, dep := range blocked {
+		c := &changes[planned[name]]
+		c.Action = installer.ActionSkip
+		c.Reason = fmt.Sprintf("declared %s depends on it", dep)
+		c.Details = nil
+	}
+	removing := make(map[string]struct{}, len(ok))
+	for _, name := range ok {
+		removing[name] = struct{}{}
+	}
+	for _, name := range gone {
+		if _, ok := removing[name]; ok {
+			continue
+		}
+		changes = append(changes, installer.Change{Manager: d.Name(), Action: installer.ActionRemove, Name: name, Reason: "depends on a removed package"})
+	}
+	return changes, nil
+}
+
+// declared returns the base names of the apt section
+func (d *DebianDriver) declared() map[string]struct{} {
+	declared := make(map[string]struct{}, len(d.Packages))
+	for name := range d.Packages {
+		declared[baseName(name)] = struct{}{}
+	}
+	return declared
+}
+
+// splitRemovals simulates the removal of pkgs. Packages whose removal would
+// take along a package for which keep reports true are blocked, with that
+// package; gone is everything removing the others removes.
+func splitRemovals(ctx context.Context, pkgs []string, keep func(string) bool) (ok []string, blocked map[string]string, gone []string, err error) {
+	blocked = make(map[string]string)
+	if gone, err = simulateRemove(ctx, pkgs); err != nil {
+		return nil, nil, nil, err
+	}
+	if !slices.ContainsFunc(gone, keep) {
+		return pkgs, blocked, gone, nil
+	}
+	// find out which of them takes the kept packages along
+	for _, p := range pkgs {
+		g, err := simulateRemove(ctx, []string{p})
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if i := slices.IndexFunc(g, keep); i != -1 {
+			blocked[p] = g[i]
+			continue
+		}
+		ok = append(ok, p)
+	}
+	if gone, err = simulateRemove(ctx, ok); err != nil {
+		return nil, nil, nil, err
+	}
+	return ok, blocked, gone, nil
+}
+
+// simulateRemove lists every package `apt-get remove pkgs` would remove
+func simulateRemove(ctx context.Context, pkgs []string) ([]string, error) {
+	if len(pkgs) == 0 {
+		return nil, nil
+	}
+	args := append([]string{"-s", "remove"}, pkgs...)
+	out, err := exec.CommandContext(ctx, "apt-get", args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("apt-get -s remove failed: %w", err)
+	}
+	return removedPackages(out), nil
+}
+
+// autoremovable lists the packages `apt-get autoremove` would remove now
+func autoremovable(ctx context.Context) ([]string, error) {
+	out, err := exec.CommandContext(ctx, "apt-get", "-s", "autoremove").Output()
+	if err != nil {
+		return nil, fmt.Errorf("apt-get -s autoremove failed: %w", err)
+	}
+	return removedPackages(out), nil
+}
+
+// removedPackages parses the "Remv libfoo1 [1.2-3]" lines of a simulation
+func removedPackages(out []byte) []string {
+	var names []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if rest, ok := strings.CutPrefix(line, "Remv "); ok {
+			name, _, _ := strings.Cut(rest, " ")
+			names = append(names, baseName(name))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// remove removes, or with Purge purges, pkgs in a single apt transaction. A
+// package whose removal would take along anything not in pkgs, e.g. a
+// protected package planned as a skip, is kept; nothing else is autoremoved.
+func (d *DebianDriver) remove(ctx context.Context, pkgs []string) error {
+	if len(pkgs) == 0 {
+		return nil
+	}
+	planned := make(map[string]struct{}, len(pkgs))
+	for _, p := range pkgs {
+		planned[baseName(p)] = struct{}{}
+	}
+	ok, blocked, _, err := splitRemovals(ctx, utils.SortedKeys(planned), func(name string) bool {
+		_, isPlanned := planned[name]
+		return !isPlanned
+	})
+	if err != nil {
+		return err
+	}
+	for _, name := range utils.SortedKeys(blocked) {
+		fmt.Printf("ℹ️ Kept %s, removing it would also remove %s\n", name, blocked[name])
+	}
+	if len(ok) == 0 {
+		return nil
+	}
+
+	verb, op := "Removing", "remove"
+	if d.Purge {
+		verb, op = "Purging", "purge"
+	}
+	fmt.Printf("🗑️ %s %d apt packages: %s\n", verb, len(ok), strings.Join(ok, " "))
+	args := append([]string{"apt-get", op, "-y"}, ok...)
+	_, err = utils.RunCommand("sudo", args...)
+	return err
+}
@@ -0,0 +1,268 @@
+package apt
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/aottr/sth/internal"
+	"github.com/aottr/sth/internal/cassette"
+	"github.com/aottr/sth/internal/installer"
+	"github.com/aottr/sth/internal/platform"
+	"github.com/aottr/sth/internal/utils"
+)
+
+const (
+	sourcesDir  = "/etc/apt/sources.list.d"
+	keyringsDir = "/etc/apt/keyrings"
+
+	// managedPrefix marks files written by sth so that stale ones can be removed
+	managedPrefix = "sth-"
+)
+
+// SyncRepositories writes a deb822 .sources file and keyring for every declared
+// repository and removes sth-managed sources that are no longer declared.
+// Files are only rewritten when their content changes. It reports whether
+// anything changed, in which case the apt lists must be refreshed.
+func SyncRepositories(repos []internal.AptRepository) (bool, error) {
+	pi := platform.GetPlatformInfo()
+	changed := false
+	want := make(map[string]struct{})
+
+	for _, repo := range repos {
+		name := strings.TrimSpace(repo.Name)
+		if name == "" {
+			return changed, fmt.Errorf("apt repository without name")
+		}
+		// the name becomes a path written as root
+		if err := repo.CheckName(); err != nil {
+			return changed, err
+		}
+
+		key, err := repositoryKey(repo)
+		if err != nil {
+			return changed, fmt.Errorf("apt repository %s: %w", name, err)
+		}
+		keyPath := filepath.Join(keyringsDir, managedPrefix+name+keyExtension(key))
+		sourcePath := filepath.Join(sourcesDir, managedPrefix+name+".sources")
+		want[filepath.Base(keyPath)] = struct{}{}
+		want[filepath.Base(sourcePath)] = struct{}{}
+
+		source, err := renderSource(repo, keyPath, pi)
+		if err != nil {
+			return changed, fmt.Errorf("apt repository %s: %w", name, err)
+		}
+
+		wrote, err := writeRootFile(keyPath, key)
+		if err != nil {
+			return changed, err
+		}
+		changed = changed || wrote
+		wrote, err = writeRootFile(sourcePath, source)
+		if err != nil {
+			return changed, err
+		}
+		if wrote {
+			fmt.Printf("🔑 Configured apt repository: %s\n", name)
+		}
+		changed = changed || wrote
+	}
+
+	removed, err := removeStale(want)
+	if err != nil {
+		return changed, err
+	}
+	return changed || removed, nil
+}
+
+// planRepositories reports repositories whose .sources file is missing or
+// outdated and sth-managed sources that are no longer declared. Keys are not
+// downloaded; a missing keyring alone marks the repository for configuration.
+func planRepositories(manager string, repos []internal.AptRepository) []installer.Change {
+	pi := platform.GetPlatformInfo()
+	var changes []installer.Change
+	want := make(map[string]struct{}, len(repos))
+	for _, repo := range repos {
+		name := strings.TrimSpace(repo.Name)
+		sourcePath := filepath.Join(sourcesDir, managedPrefix+name+".sources")
+		want[filepath.Base(sourcePath)] = struct{}{}
+
+		change := installer.Change{Manager: manager, Action: installer.ActionConfigure, Name: name, Reason: "apt repository"}
+		keyPath := existingKeyring(name)
+		if keyPath == "" {
+			change.Details = []string{"add keyring and source " + sourcePath}
+			changes = append(changes, change)
+			continue
+		}
+		source, err := renderSource(repo, keyPath, pi)
+		cur, readErr := os.ReadFile(sourcePath)
+		if err != nil || readErr != nil || !bytes.Equal(cur, source) {
+			change.Details = []string{"write " + sourcePath}
+			changes = append(changes, change)
+			continue
+		}
+		changes = append(changes, installer.Change{Manager: manager, Action: installer.ActionSkip, Name: name, Reason: "apt repository configured"})
+	}
+
+	entries, _ := os.ReadDir(sourcesDir)
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Name(), managedPrefix) || !strings.HasSuffix(e.Name(), ".sources") {
+			continue
+		}
+		if _, ok := want[e.Name()]; ok {
+			continue
+		}
+		name := strings.TrimSuffix(strings.TrimPrefix(e.Name(), managedPrefix), ".sources")
+		changes = append(changes, installer.Change{
+			Manager: manager,
+			Action:  installer.ActionRemove,
+			Name:    name,
+			Reason:  "apt repository",
+			Details: []string{"remove " + filepath.Join(sourcesDir, e.Name())},
+			Spec:    internal.AptRepository{Name: name},
+		})
+	}
+	return changes
+}
+
+func existingKeyring(name string) string {
+	for _, ext := range []string{".asc", ".gpg"} {
+		p := filepath.Join(keyringsDir, managedPrefix+name+ext)
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+	}
+	return ""
+}
+
+// renderSource builds the deb822 stanza. URIs and Suites may use templates
+// over platform.Info, e.g. "{{.Codename}}".
+func renderSource(repo internal.AptRepository, keyPath string, pi platform.Info) ([]byte, error) {
+	if len(repo.URIs) == 0 || len(repo.Suites) == 0 {
+		return nil, fmt.Errorf("uris and suites are required")
+	}
+	uris, err := renderAll(repo.URIs, pi)
+	if err != nil {
+		return nil, err
+	}
+	suites, err := renderAll(repo.Suites, pi)
+	if err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	b.WriteString("# Managed by sth, do not edit\n")
+	b.WriteString("Types: deb\n")
+	fmt.Fprintf(&b, "URIs: %s\n", strings.Join(uris, " "))
+	fmt.Fprintf(&b, "Suites: %s\n", strings.Join(suites, " "))
+	if len(repo.Components) > 0 {
+		fmt.Fprintf(&b, "Components: %s\n", strings.Join(repo.Components, " "))
+	}
+	if len(repo.Architectures) > 0 {
+		fmt.Fprintf(&b, "Architectures: %s\n", strings.Join(repo.Architectures, " "))
+	}
+	fmt.Fprintf(&b, "Signed-By: %s\n", keyPath)
+	return []byte(b.String()), nil
+}
+
+func renderAll(in []string, pi platform.Info) ([]string, error) {
+	out := make([]string, 0, len(in))
+	for _, s := range in {
+		t, err := template.New("repo").Option("missingkey=error").Parse(s)
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err := t.Execute(&buf, pi); err != nil {
+			return nil, err
+		}
+		out = append(out, strings.TrimSpace(buf.String()))
+	}
+	return out, nil
+}
+
+// repositoryKey returns the inline key or downloads KeyURL
+func repositoryKey(repo internal.AptRepository) ([]byte, error) {
+	if k := strings.TrimSpace(repo.Key); k != "" {
+		return []byte(k + "\n"), nil
+	}
+	if strings.TrimSpace(repo.KeyURL) == "" {
+		return nil, fmt.Errorf("key or keyUrl is required")
+	}
+	client := cassette.Client(30 * time.Second)
+	resp, err := client.Get(repo.KeyURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to GET key: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("HTTP status %d when fetching key", resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// keyExtension lets apt tell armored (.asc) from binary (.gpg) keyrings
+func keyExtension(key []byte) string {
+	if bytes.Contains(key, []byte("-----BEGIN PGP PUBLIC KEY BLOCK-----")) {
+		return ".asc"
+	}
+	return ".gpg"
+}
+
+// writeRootFile installs data at path via sudo unless it already has that content
+func writeRootFile(path string, data []byte) (bool, error) {
+	if cur, err := os.ReadFile(path); err == nil && bytes.Equal(cur, data) {
+		return false, nil
+	}
+	tmp, err := os.CreateTemp("", "sth-apt-*")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return false, err
+	}
+	if err := tmp.Close(); err != nil {
+		return false, err
+	}
+	if _, err := utils.RunCommand("sudo", "install", "-D", "-m", "0644", tmp.Name(), path); err != nil {
+		return false, fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return true, nil
+}
+
+// removeStale deletes sth-managed sources and keyrings not in want
+func removeStale(want map[string]struct{}) (bool, error) {
+	removed := false
+	for _, dir := range []string{sourcesDir, keyringsDir} {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return removed, err
+		}
+		for _, e := range entries {
+			name := e.Name()
+			if !strings.HasPrefix(name, managedPrefix) {
+				continue
+			}
+			if _, ok := want[name]; ok {
+				continue
+			}
+			path := filepath.Join(dir, name)
+			fmt.Printf("🗑️ Removing stale apt source file: %s\n", path)
+			if _, err := utils.RunCommand("sudo", "rm", "-f", path); err != nil {
+				return removed, err
+			}
+			removed = true
+		}
+	}
+	return removed, nil
+}
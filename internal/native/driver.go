@@ -2,53 +2,47 @@ package native
 
 import (
 	"fmt"
+	"os"
 	"strings"
+	"time"
 
-	"github.com/aottr/sth/internal"
+	"github.com/aottr/sth/internal/installer"
 	"github.com/aottr/sth/internal/native/apt"
 	"github.com/aottr/sth/internal/platform"
 )
 
-type Op string
-
-const (
-	OpEq   Op = "="
-	OpGe   Op = ">="
-	OpNone Op = "" // treat as exact upstream equality
-)
-
-type VersionConstraint struct {
-	Op    Op
-	Value string // user-specified version (may be upstream-only)
-}
-
-func ParseConstraint(s string) VersionConstraint {
-	s = strings.TrimSpace(s)
-	switch {
-	case strings.HasPrefix(s, ">="):
-		return VersionConstraint{Op: OpGe, Value: strings.TrimSpace(s[2:])}
-	case strings.HasPrefix(s, "="):
-		return VersionConstraint{Op: OpEq, Value: strings.TrimSpace(s[1:])}
-	default:
-		// bare value, treat as exact upstream equality
-		return VersionConstraint{Op: OpNone, Value: s}
-	}
-}
-
-func GetDriverForRelease(family string, packages *internal.Packages) (Driver, error) {
+// GetDriverForRelease returns the native package manager for a distro family
+func GetDriverForRelease(family string) (Driver, error) {
 
 	switch family {
 	case platform.FamilyDebian:
-		if packages == nil {
-			return apt.New(map[string]string{}), nil
+		d := apt.New(map[string]string{})
+		if ttl, ok := aptUpdateTTL(); ok {
+			d.UpdateTTL = ttl
 		}
-		return apt.New(packages.Apt), nil
+		return d, nil
 	}
 
 	return nil, fmt.Errorf("unsupported system: %s", family)
 }
 
+// aptUpdateTTL reads STH_APT_UPDATE_TTL (e.g. "30m", "0" to always update)
+func aptUpdateTTL() (time.Duration, bool) {
+	v := strings.TrimSpace(os.Getenv("STH_APT_UPDATE_TTL"))
+	if v == "" {
+		return 0, false
+	}
+	if v == "0" {
+		return 0, true
+	}
+	ttl, err := time.ParseDuration(v)
+	if err != nil {
+		fmt.Printf("⚠️ ignoring invalid STH_APT_UPDATE_TTL %q: %v\n", v, err)
+		return 0, false
+	}
+	return ttl, true
+}
+
 type Driver interface {
-	InstallAll() error
-	Install([]string) error
+	installer.Installer
 }
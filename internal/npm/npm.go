@@ -0,0 +1,108 @@
+package npm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/aottr/sth/internal"
+	"github.com/aottr/sth/internal/installer"
+	"github.com/aottr/sth/internal/utils"
+)
+
+type lsOutput struct {
+	Dependencies map[string]struct {
+		Version string `json:"version"`
+	} `json:"dependencies"`
+}
+
+// Installed returns globally installed packages via `npm ls -g --json`
+func Installed(ctx context.Context) (map[string]string, error) {
+	out, err := exec.CommandContext(ctx, "npm", "ls", "-g", "--json", "--depth=0").Output()
+	if err != nil {
+		// npm ls exits non-zero on peer dependency problems but still prints the tree
+		if _, ok := err.(*exec.ExitError); !ok || len(out) == 0 {
+			return nil, fmt.Errorf("npm ls failed: %w", err)
+		}
+	}
+	var ls lsOutput
+	if err := json.Unmarshal(out, &ls); err != nil {
+		return nil, fmt.Errorf("failed to parse npm ls: %w", err)
+	}
+	installed := make(map[string]string, len(ls.Dependencies))
+	for name, dep := range ls.Dependencies {
+		installed[name] = dep.Version
+	}
+	return installed, nil
+}
+
+// Driver installs global npm packages
+type Driver struct{}
+
+func New() *Driver { return &Driver{} }
+
+func (d *Driver) Name() string { return string(internal.PackageTypeNpm) }
+
+func (d *Driver) Detect() bool {
+	_, err := exec.LookPath("npm")
+	return err == nil
+}
+
+func (d *Driver) Installed(ctx context.Context) ([]installer.Package, error) {
+	installed, err := Installed(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return installer.Packages(installed), nil
+}
+
+func (d *Driver) Plan(ctx context.Context, desired *internal.Packages) ([]installer.Change, error) {
+	if len(desired.Npm) == 0 {
+		return nil, nil
+	}
+	if !d.Detect() {
+		return installer.SkipAll(d.Name(), utils.SortedKeys(desired.Npm), "npm not available"), nil
+	}
+	installed, err := Installed(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return installer.PlanVersions(d.Name(), desired.Npm, installed), nil
+}
+
+// Apply installs missing or mismatched packages in one `npm install -g`
+func (d *Driver) Apply(ctx context.Context, plan []installer.Change) error {
+	var specs []string
+	for _, c := range installer.ByAction(plan, installer.ActionInstall, installer.ActionUpgrade) {
+		if c.To != "" {
+			specs = append(specs, c.Name+"@"+strings.TrimPrefix(c.To, "v"))
+		} else {
+			specs = append(specs, c.Name+"@latest")
+		}
+	}
+	if len(specs) > 0 {
+		fmt.Printf("📦 Installing npm packages: %s\n", strings.Join(specs, " "))
+		if err := run(ctx, append([]string{"install", "-g"}, specs...)...); err != nil {
+			return fmt.Errorf("npm install failed: %w", err)
+		}
+	}
+	return Uninstall(ctx, installer.Names(installer.ByAction(plan, installer.ActionRemove)))
+}
+
+// Uninstall removes the named global packages
+func Uninstall(ctx context.Context, names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	fmt.Printf("🗑️ Uninstalling npm packages: %s\n", strings.Join(names, " "))
+	return run(ctx, append([]string{"uninstall", "-g"}, names...)...)
+}
+
+func run(ctx context.Context, args ...string) error {
+	cmd := exec.CommandContext(ctx, "npm", args...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	return cmd.Run()
+}
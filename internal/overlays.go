@@ -0,0 +1,137 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/aottr/sth/internal/platform"
+	"github.com/aottr/sth/internal/utils"
+)
+
+// resolve merges includes and overlays into the sections as loaded from the file
+func (p *Packages) resolve() error {
+	if err := p.applyIncludes(); err != nil {
+		return err
+	}
+	if err := p.applyOverlays(); err != nil {
+		return err
+	}
+	return p.resolveTools(platform.GetPlatformInfo())
+}
+
+// applyOverlays merges the selected profiles, in the order given, and then
+// every matching host overlay, in sorted glob order, into the base sections.
+// Later entries win over earlier ones with the same name.
+func (p *Packages) applyOverlays() error {
+	p.Merged = nil
+	for _, inc := range p.Included {
+		p.Merged = append(p.Merged, "include "+inc)
+	}
+	p.Merged = append(p.Merged, "base")
+	for _, name := range p.profiles {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		profile, ok := p.Profiles[name]
+		if !ok {
+			return fmt.Errorf("unknown profile %q, known profiles: %s", name, strings.Join(utils.SortedKeys(p.Profiles), ", "))
+		}
+		p.Sections.merge(profile)
+		p.Merged = append(p.Merged, "profile "+name)
+	}
+
+	if len(p.Hosts) > 0 {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("failed to get hostname: %w", err)
+		}
+		for _, glob := range utils.SortedKeys(p.Hosts) {
+			ok, err := path.Match(glob, hostname)
+			if err != nil {
+				return fmt.Errorf("invalid host pattern %q: %w", glob, err)
+			}
+			if ok {
+				p.Sections.merge(p.Hosts[glob])
+				p.Merged = append(p.Merged, "host "+glob)
+			}
+		}
+	}
+
+	// only explain skipped entries of scopes that were merged
+	p.Skipped = nil
+	for _, s := range p.skipped {
+		if s.Scope == "" || slices.Contains(p.Merged, s.Scope) {
+			p.Skipped = append(p.Skipped, s)
+		}
+	}
+	return nil
+}
+
+// merge adds the entries of o to s. Versions and options from o replace
+// those of entries with the same name.
+func (s *Sections) merge(o Sections) {
+	s.Apt = mergeMap(s.Apt, o.Apt)
+	s.Pipx = mergeMap(s.Pipx, o.Pipx)
+	s.Cargo = mergeMap(s.Cargo, o.Cargo)
+	s.Go = mergeMap(s.Go, o.Go)
+	s.Npm = mergeMap(s.Npm, o.Npm)
+
+	s.Flatpak = mergeList(s.Flatpak, o.Flatpak, func(r FlatpakRef) string { return r.ID })
+	s.Snap = mergeList(s.Snap, o.Snap, func(p SnapPackage) string { return p.Name })
+	s.Recipes = mergeList(s.Recipes, o.Recipes, func(r string) string { return r })
+	s.Brew.Taps = mergeList(s.Brew.Taps, o.Brew.Taps, func(t BrewTap) string { return t.Name })
+	s.Brew.Formulae = mergeList(s.Brew.Formulae, o.Brew.Formulae, func(f BrewFormula) string { return f.Name })
+	s.Brew.Casks = mergeList(s.Brew.Casks, o.Brew.Casks, func(c BrewCask) string { return c.Name })
+	s.AptRepositories = mergeList(s.AptRepositories, o.AptRepositories, func(r AptRepository) string { return r.Name })
+	s.FlatpakRemotes = mergeList(s.FlatpakRemotes, o.FlatpakRemotes, func(r FlatpakRemote) string { return r.Name })
+	s.Tools = mergeTools(s.Tools, o.Tools)
+	s.Protected = mergeList(s.Protected, o.Protected, func(p string) string { return p })
+}
+
+func mergeMap(dst, src map[string]string) map[string]string {
+	if len(src) == 0 {
+		return dst
+	}
+	if dst == nil {
+		dst = make(map[string]string, len(src))
+	}
+	for k, v := range src {
+		dst[k] = v
+	}
+	return dst
+}
+
+// mergeList appends the items of src to dst, replacing items of dst with the
+// same key in place so the order stays stable
+func mergeList[T any](dst, src []T, key func(T) string) []T {
+	index := make(map[string]int, len(dst))
+	for i, item := range dst {
+		index[key(item)] = i
+	}
+	for _, item := range src {
+		if i, ok := index[key(item)]; ok {
+			dst[i] = item
+			continue
+		}
+		index[key(item)] = len(dst)
+		dst = append(dst, item)
+	}
+	return dst
+}
+
+func mergeTools(dst, src map[string]Tool) map[string]Tool {
+	if len(src) == 0 {
+		return dst
+	}
+	if dst == nil {
+		dst = make(map[string]Tool, len(src))
+	}
+	for k, v := range src {
+		dst[k] = v
+	}
+	return dst
+}
@@ -1,7 +1,9 @@
 package internal
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 
 	"github.com/aottr/sth/internal/platform"
@@ -15,68 +17,197 @@ const (
 	PackageTypeApt     PackageType = "apt"
 	PackageTypeFlatpak PackageType = "flatpak"
 	PackageTypeBrew    PackageType = "brew"
+	PackageTypeSnap    PackageType = "snap"
+	PackageTypePipx    PackageType = "pipx"
+	PackageTypeCargo   PackageType = "cargo"
+	PackageTypeGo      PackageType = "go"
+	PackageTypeNpm     PackageType = "npm"
 	PackageTypeRecipe  PackageType = "recipes"
 )
 
 type Packages struct {
-	path string
-
-	Name     *string           `yaml:"name,omitempty"`
-	Platform platform.Info     `yaml:"platform"`
-	Apt      map[string]string `yaml:"apt"`
-	Flatpak  []string          `yaml:"flatpak"`
-	Brew     []string          `yaml:"brew"`
-	Recipes  []string          `yaml:"recipes"`
+	path     string
+	profiles []string
+	// entries of all scopes left out on this machine, see Skipped
+	skipped []Skipped
+
+	Name     *string       `yaml:"name,omitempty"`
+	Platform platform.Info `yaml:"platform"`
+	Include  []Include     `yaml:"include,omitempty"`
+	Sections `yaml:",inline"`
+
+	// Profiles are named subsets selected with --profile or STH_PROFILE
+	Profiles map[string]Sections `yaml:"profiles,omitempty"`
+	// Hosts are overlays applied when the hostname matches the glob key
+	Hosts map[string]Sections `yaml:"hosts,omitempty"`
+
+	// Included are the files and URLs merged in through Include, in order
+	Included []string `yaml:"-"`
+	// Merged lists what was merged into the sections on load, e.g.
+	// ["include base.yml", "base", "profile backend", "host ci-*"]
+	Merged []string `yaml:"-"`
+	// Skipped are the entries of the merged scopes left out on this
+	// machine, by their when: condition or because no tool backend applies
+	Skipped []Skipped `yaml:"-"`
 }
 
-func LoadPackages(path string) (*Packages, error) {
+// Sections are the package lists of the base config, a profile or a host overlay
+type Sections struct {
+	Apt     map[string]string `yaml:"apt,omitempty"`
+	Flatpak []FlatpakRef      `yaml:"flatpak,omitempty"`
+	Brew    Brew              `yaml:"brew,omitempty"`
+	Recipes []string          `yaml:"recipes,omitempty"`
+	Snap    []SnapPackage     `yaml:"snap,omitempty"`
+
+	// language package managers, name -> version ("latest" or a pin)
+	Pipx  map[string]string `yaml:"pipx,omitempty"`
+	Cargo map[string]string `yaml:"cargo,omitempty"`
+	Go    map[string]string `yaml:"go,omitempty"` // package path, e.g. golang.org/x/tools/gopls
+	Npm   map[string]string `yaml:"npm,omitempty"`
+
+	AptRepositories []AptRepository `yaml:"aptRepositories,omitempty"`
+	FlatpakRemotes  []FlatpakRemote `yaml:"flatpakRemotes,omitempty"`
+
+	// Tools are logical tools resolved to one of the sections above
+	Tools map[string]Tool `yaml:"tools,omitempty"`
+
+	// Protected are never removed by `sth sync --prune`. Entries are glob
+	// patterns, optionally scoped to a manager, e.g. "apt:linux-image-*".
+	Protected []string `yaml:"protected,omitempty"`
+}
+
+// LoadPackages reads packages.yml, merges its includes and then the selected
+// profiles and the overlays of the hosts matching this machine's hostname
+// into the base sections
+func LoadPackages(path string, profiles ...string) (*Packages, error) {
 	packages := &Packages{
-		path: path,
+		path:     path,
+		profiles: profiles,
 	}
 
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read %s: %v", path, err)
 	}
-	if err := yaml.Unmarshal(data, &packages); err != nil {
-		return nil, fmt.Errorf("failed to parse YAML: %v", err)
+	packages.skipped, err = decodePackages(data, packages, platform.GetPlatformInfo())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := packages.resolve(); err != nil {
+		return nil, err
 	}
 	return packages, nil
 }
 
+// Add appends packages to a section of packages.yml. Names that are
+// already declared are left alone.
 func (p *Packages) Add(PackageType PackageType, pkgs []string) error {
+	return p.edit(func(doc *document) bool {
+		changed := false
+		for _, pkg := range pkgs {
+			changed = doc.addEntry(PackageType, pkg) || changed
+		}
+		return changed
+	})
+}
+
+// Remove deletes the named entries from a section of packages.yml and
+// returns the names that were removed. For brew, names are removed from
+// formulae, casks and taps.
+func (p *Packages) Remove(PackageType PackageType, pkgs []string) ([]string, error) {
+	names := make(map[string]struct{}, len(pkgs))
 	for _, pkg := range pkgs {
-		p.addOne(PackageType, pkg)
+		names[pkg] = struct{}{}
 	}
-	if err := p.saveConfig(); err != nil {
-		return err
-	}
-	return nil
+	var removed []string
+	err := p.edit(func(doc *document) bool {
+		section := doc.section(string(PackageType))
+		switch PackageType {
+		case PackageTypeFlatpak:
+			removed = doc.removeEntries(section, names, "id")
+		case PackageTypeBrew:
+			if section != nil && section.Kind == yaml.SequenceNode {
+				removed = doc.removeEntries(section, names, "name")
+				break
+			}
+			for _, key := range []string{"formulae", "casks", "taps"} {
+				removed = append(removed, doc.removeEntries(mappingValue(section, key), names, "name")...)
+			}
+		default:
+			removed = doc.removeEntries(section, names, "name")
+		}
+		return len(removed) > 0
+	})
+	return removed, err
 }
 
-func (p *Packages) addOne(PackageType PackageType, pkg string) {
+// edit applies fn to the YAML document of packages.yml while holding its
+// lock, writes the result if fn reports a change and reloads p from it.
+// Editing the nodes keeps comments, key order and formatting of everything
+// fn does not touch.
+func (p *Packages) edit(fn func(doc *document) bool) error {
+	unlock, err := utils.LockFile(p.path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
-	switch PackageType {
-	case PackageTypeApt:
-		p.Apt[pkg] = "latest"
-	case PackageTypeFlatpak:
-		p.Flatpak = append(p.Flatpak, pkg)
-	case PackageTypeRecipe:
-		p.Recipes = append(p.Recipes, pkg)
+	doc, err := readDocument(p.path)
+	if err != nil {
+		return err
 	}
+	if !fn(doc) {
+		return nil
+	}
+	data, err := doc.encode()
+	if err != nil {
+		return err
+	}
+	if err := utils.WriteFileAtomic(p.path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write packages.yml: %v", err)
+	}
+	*p = Packages{path: p.path, profiles: p.profiles}
+	if p.skipped, err = decodeNode(&doc.root, p, platform.GetPlatformInfo()); err != nil {
+		return err
+	}
+	return p.resolve()
 }
 
+// saveConfig writes p to its path. An existing file is updated through its
+// YAML document, so the sections that did not change keep their comments.
 func (p *Packages) saveConfig() error {
-	data, err := yaml.Marshal(p)
+	unlock, err := utils.LockFile(p.path)
 	if err != nil {
-		return fmt.Errorf("failed to marshal YAML: %v", err)
+		return err
+	}
+	defer unlock()
+
+	doc, err := readDocument(p.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		doc, err = parseDocument(nil)
 	}
-	if err := os.WriteFile(p.path, data, 0644); err != nil {
+	if err != nil {
+		return err
+	}
+	if err := doc.update(p); err != nil {
+		return err
+	}
+	data, err := doc.encode()
+	if err != nil {
+		return err
+	}
+	if err := utils.WriteFileAtomic(p.path, data, 0644); err != nil {
 		return fmt.Errorf("failed to write packages.yml: %v", err)
 	}
 	return nil
 }
 
+// SaveAs writes the config to path, which is used for later saves
+func (p *Packages) SaveAs(path string) error {
+	p.path = path
+	return p.saveConfig()
+}
+
 func Init(path, name string) (*Packages, error) {
 	packages := &Packages{
 		path:     path,
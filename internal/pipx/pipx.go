@@ -0,0 +1,112 @@
+package pipx
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/aottr/sth/internal"
+	"github.com/aottr/sth/internal/installer"
+	"github.com/aottr/sth/internal/utils"
+)
+
+type listOutput struct {
+	Venvs map[string]struct {
+		Metadata struct {
+			MainPackage struct {
+				Package        string `json:"package"`
+				PackageVersion string `json:"package_version"`
+			} `json:"main_package"`
+		} `json:"metadata"`
+	} `json:"venvs"`
+}
+
+// Installed returns installed pipx packages and their versions via `pipx list --json`
+func Installed(ctx context.Context) (map[string]string, error) {
+	out, err := exec.CommandContext(ctx, "pipx", "list", "--json").Output()
+	if err != nil {
+		return nil, fmt.Errorf("pipx list failed: %w", err)
+	}
+	var list listOutput
+	if err := json.Unmarshal(out, &list); err != nil {
+		return nil, fmt.Errorf("failed to parse pipx list: %w", err)
+	}
+	installed := make(map[string]string, len(list.Venvs))
+	for name, venv := range list.Venvs {
+		pkg := utils.WithDefault(venv.Metadata.MainPackage.Package, name)
+		installed[pkg] = venv.Metadata.MainPackage.PackageVersion
+	}
+	return installed, nil
+}
+
+// Driver installs Python applications with pipx
+type Driver struct{}
+
+func New() *Driver { return &Driver{} }
+
+func (d *Driver) Name() string { return string(internal.PackageTypePipx) }
+
+func (d *Driver) Detect() bool {
+	_, err := exec.LookPath("pipx")
+	return err == nil
+}
+
+func (d *Driver) Installed(ctx context.Context) ([]installer.Package, error) {
+	installed, err := Installed(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return installer.Packages(installed), nil
+}
+
+func (d *Driver) Plan(ctx context.Context, desired *internal.Packages) ([]installer.Change, error) {
+	if len(desired.Pipx) == 0 {
+		return nil, nil
+	}
+	if !d.Detect() {
+		return installer.SkipAll(d.Name(), utils.SortedKeys(desired.Pipx), "pipx not available"), nil
+	}
+	installed, err := Installed(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return installer.PlanVersions(d.Name(), desired.Pipx, installed), nil
+}
+
+// Apply installs packages that are missing or do not match their pinned version
+func (d *Driver) Apply(ctx context.Context, plan []installer.Change) error {
+	for _, c := range installer.ByAction(plan, installer.ActionInstall, installer.ActionUpgrade) {
+		spec := c.Name
+		if c.To != "" {
+			spec = c.Name + "==" + c.To
+		}
+		args := []string{"install", spec}
+		if c.Action == installer.ActionUpgrade {
+			args = []string{"install", "--force", spec}
+		}
+		fmt.Printf("📦 Installing pipx package: %s\n", spec)
+		if err := run(ctx, args...); err != nil {
+			return fmt.Errorf("pipx install %s failed: %w", spec, err)
+		}
+	}
+	return Uninstall(ctx, installer.Names(installer.ByAction(plan, installer.ActionRemove)))
+}
+
+// Uninstall removes the named packages
+func Uninstall(ctx context.Context, names []string) error {
+	for _, name := range names {
+		fmt.Printf("🗑️ Uninstalling pipx package: %s\n", name)
+		if err := run(ctx, "uninstall", name); err != nil {
+			return fmt.Errorf("pipx uninstall %s failed: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func run(ctx context.Context, args ...string) error {
+	cmd := exec.CommandContext(ctx, "pipx", args...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	return cmd.Run()
+}
@@ -0,0 +1,278 @@
+package platform
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Eval evaluates a `when:` condition against info. The grammar is
+//
+//	expr       = or
+//	or         = and { ("||" | "or") and }
+//	and        = unary { ("&&" | "and") unary }
+//	unary      = ("!" | "not") unary | "(" expr ")" | comparison
+//	comparison = field op value | field "in" "[" value { "," value } "]"
+//	field      = "os" | "distro" | "family" | "arch" | "version" | "codename"
+//	op         = "==" | "!=" | "~=" | "<" | "<=" | ">" | ">="
+//
+// Values are bare words or quoted strings. "~=" matches a glob, and the
+// ordering operators compare dotted versions numerically, e.g.
+// `family == debian && version >= 22.04`.
+func Eval(expr string, info Info) (bool, error) {
+	toks, err := tokenize(expr)
+	if err != nil {
+		return false, err
+	}
+	p := &exprParser{toks: toks, info: info}
+	ok, err := p.or()
+	if err != nil {
+		return false, fmt.Errorf("when %q: %w", expr, err)
+	}
+	if p.pos < len(p.toks) {
+		return false, fmt.Errorf("when %q: unexpected %q", expr, p.toks[p.pos].text)
+	}
+	return ok, nil
+}
+
+type tokKind int
+
+const (
+	tokWord tokKind = iota
+	tokString
+	tokOp
+)
+
+type token struct {
+	kind tokKind
+	text string
+}
+
+func tokenize(s string) ([]token, error) {
+	var toks []token
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '"' || c == '\'':
+			end := strings.IndexRune(s[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string in %q", s)
+			}
+			toks = append(toks, token{tokString, s[i+1 : i+1+end]})
+			i += end + 2
+		case strings.ContainsRune("()[],", c):
+			toks = append(toks, token{tokOp, string(c)})
+			i++
+		case strings.ContainsRune("=!~<>&|", c):
+			j := i + 1
+			if j < len(s) && strings.ContainsRune("=&|", rune(s[j])) {
+				j++
+			}
+			toks = append(toks, token{tokOp, s[i:j]})
+			i = j
+		default:
+			j := i
+			for j < len(s) && !unicode.IsSpace(rune(s[j])) && !strings.ContainsRune("()[],=!~<>&|\"'", rune(s[j])) {
+				j++
+			}
+			toks = append(toks, token{tokWord, s[i:j]})
+			i = j
+		}
+	}
+	return toks, nil
+}
+
+type exprParser struct {
+	toks []token
+	pos  int
+	info Info
+}
+
+func (p *exprParser) peek() (token, bool) {
+	if p.pos >= len(p.toks) {
+		return token{}, false
+	}
+	return p.toks[p.pos], true
+}
+
+// accept consumes the next token if it is one of texts
+func (p *exprParser) accept(texts ...string) bool {
+	t, ok := p.peek()
+	if !ok || t.kind == tokString {
+		return false
+	}
+	for _, text := range texts {
+		if t.text == text {
+			p.pos++
+			return true
+		}
+	}
+	return false
+}
+
+func (p *exprParser) or() (bool, error) {
+	left, err := p.and()
+	if err != nil {
+		return false, err
+	}
+	for p.accept("||", "or") {
+		right, err := p.and()
+		if err != nil {
+			return false, err
+		}
+		left = left || right
+	}
+	return left, nil
+}
+
+func (p *exprParser) and() (bool, error) {
+	left, err := p.unary()
+	if err != nil {
+		return false, err
+	}
+	for p.accept("&&", "and") {
+		right, err := p.unary()
+		if err != nil {
+			return false, err
+		}
+		left = left && right
+	}
+	return left, nil
+}
+
+func (p *exprParser) unary() (bool, error) {
+	if p.accept("!", "not") {
+		v, err := p.unary()
+		return !v, err
+	}
+	if p.accept("(") {
+		v, err := p.or()
+		if err != nil {
+			return false, err
+		}
+		if !p.accept(")") {
+			return false, fmt.Errorf("missing )")
+		}
+		return v, nil
+	}
+	return p.comparison()
+}
+
+func (p *exprParser) comparison() (bool, error) {
+	t, ok := p.peek()
+	if !ok || t.kind != tokWord {
+		return false, fmt.Errorf("expected a field")
+	}
+	p.pos++
+	actual, err := p.field(t.text)
+	if err != nil {
+		return false, err
+	}
+
+	if p.accept("in") {
+		if !p.accept("[") {
+			return false, fmt.Errorf("expected [ after in")
+		}
+		found := false
+		for {
+			v, err := p.value()
+			if err != nil {
+				return false, err
+			}
+			found = found || strings.EqualFold(actual, v)
+			if p.accept("]") {
+				return found, nil
+			}
+			if !p.accept(",") {
+				return false, fmt.Errorf("expected , or ]")
+			}
+		}
+	}
+
+	op, ok := p.peek()
+	if !ok || op.kind != tokOp {
+		return false, fmt.Errorf("expected an operator after %s", t.text)
+	}
+	p.pos++
+	want, err := p.value()
+	if err != nil {
+		return false, err
+	}
+	switch op.text {
+	case "==":
+		return strings.EqualFold(actual, want), nil
+	case "!=":
+		return !strings.EqualFold(actual, want), nil
+	case "~=":
+		return path.Match(strings.ToLower(want), strings.ToLower(actual))
+	case "<":
+		return compareVersions(actual, want) < 0, nil
+	case "<=":
+		return compareVersions(actual, want) <= 0, nil
+	case ">":
+		return compareVersions(actual, want) > 0, nil
+	case ">=":
+		return compareVersions(actual, want) >= 0, nil
+	}
+	return false, fmt.Errorf("unknown operator %q", op.text)
+}
+
+func (p *exprParser) value() (string, error) {
+	t, ok := p.peek()
+	if !ok || t.kind == tokOp {
+		return "", fmt.Errorf("expected a value")
+	}
+	p.pos++
+	return t.text, nil
+}
+
+func (p *exprParser) field(name string) (string, error) {
+	switch name {
+	case "os":
+		return p.info.OS, nil
+	case "distro":
+		return p.info.Distro, nil
+	case "family":
+		return p.info.Family, nil
+	case "arch":
+		return p.info.Arch, nil
+	case "version":
+		return p.info.Version, nil
+	case "codename":
+		return p.info.Codename, nil
+	}
+	return "", fmt.Errorf("unknown field %q", name)
+}
+
+// compareVersions compares dotted versions numerically, part by part.
+// Parts that are not numbers are compared as strings.
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y string
+		if i < len(as) {
+			x = as[i]
+		}
+		if i < len(bs) {
+			y = bs[i]
+		}
+		xi, errX := strconv.Atoi(x)
+		yi, errY := strconv.Atoi(y)
+		switch {
+		case errX == nil && errY == nil:
+			if xi != yi {
+				if xi < yi {
+					return -1
+				}
+				return 1
+			}
+		case x != y:
+			return strings.Compare(x, y)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,87 @@
+package platform
+
+import "testing"
+
+func TestEval(t *testing.T) {
+	info := Info{OS: "linux", Distro: "ubuntu", Family: "debian", Arch: "amd64", Version: "24.04", Codename: "noble"}
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`os == linux`, true},
+		{`os == LINUX`, true},
+		{`os == "linux"`, true},
+		{`os == 'darwin'`, false},
+		{`os != darwin`, true},
+		{`arch in [amd64, arm64]`, true},
+		{`arch in ["arm64"]`, false},
+		{`codename ~= no*`, true},
+		{`distro ~= "deb?an"`, false},
+		{`version >= 22.04`, true},
+		{`version > 24.4`, false},
+		{`version <= 24.04`, true},
+		{`version < 24.10`, true},
+		{`family == debian && version >= 22.04`, true},
+		{`family == debian and arch == arm64`, false},
+		{`os == darwin || arch == amd64`, true},
+		{`os == darwin or os == windows`, false},
+		{`!(os == darwin)`, true},
+		{`not os == linux`, false},
+		{`! os == linux || arch == amd64`, true},
+		// && binds tighter than ||
+		{`os == darwin && arch == arm64 || distro == ubuntu`, true},
+		{`os == linux || arch == arm64 && distro == fedora`, true},
+		{`(os == linux || arch == arm64) && distro == fedora`, false},
+	}
+	for _, tt := range tests {
+		got, err := Eval(tt.expr, info)
+		if err != nil {
+			t.Errorf("Eval(%q): %v", tt.expr, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Eval(%q) = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	for _, expr := range []string{
+		``,
+		`os`,
+		`os ==`,
+		`kernel == linux`,
+		`os == "linux`,
+		`(os == linux`,
+		`os == linux)`,
+		`arch in amd64`,
+		`arch in [amd64 arm64]`,
+		`os == linux &&`,
+		`os ~= "[a"`,
+	} {
+		if _, err := Eval(expr, Info{OS: "linux"}); err == nil {
+			t.Errorf("Eval(%q) succeeded, want an error", expr)
+		}
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"24.04", "24.04", 0},
+		{"24.04", "24.4", 0},
+		{"9", "10", -1},
+		{"22.04", "22.10", -1},
+		{"12", "12.1", -1},
+		{"1.10", "1.9", 1},
+		{"1.0rc1", "1.0rc2", -1},
+		{"", "", 0},
+	}
+	for _, tt := range tests {
+		if got := compareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -16,20 +16,31 @@ const (
 
 const DistroOther string = "other"
 
+const (
+	osReleasePath     = "/etc/os-release"
+	osReleaseFallback = "/usr/lib/os-release"
+	lsbReleasePath    = "/etc/lsb-release"
+)
+
 type Info struct {
-	Arch   string `yaml:"arch"`             // e.g., "amd64"
-	OS     string `yaml:"os"`               // e.g., "linux"
-	Distro string `yaml:"distro,omitempty"` // distro ID, e.g., "ubuntu"
-	Family string `yaml:"family,omitempty"` // e.g., "debian"
+	Arch     string `yaml:"arch"`               // e.g., "amd64"
+	OS       string `yaml:"os"`                 // e.g., "linux"
+	Distro   string `yaml:"distro,omitempty"`   // distro ID, e.g., "ubuntu"
+	Family   string `yaml:"family,omitempty"`   // e.g., "debian"
+	Version  string `yaml:"version,omitempty"`  // VERSION_ID, e.g., "24.04"
+	Codename string `yaml:"codename,omitempty"` // VERSION_CODENAME, e.g., "noble"
 }
 
 func GetPlatformInfo() Info {
+	rel := ReadOSRelease()
 	distro := DetectDistro()
 	return Info{
-		OS:     DetectOS(),
-		Distro: distro,
-		Family: DetectFamily(distro),
-		Arch:   DetectArch(),
+		OS:       DetectOS(),
+		Distro:   distro,
+		Family:   DetectFamily(distro, rel.IDLike...),
+		Arch:     DetectArch(),
+		Version:  rel.VersionID,
+		Codename: rel.Codename,
 	}
 }
 
@@ -51,15 +62,50 @@ func set(vals ...string) map[string]struct{} {
 	return m
 }
 
+// OSRelease holds the fields of os-release(5) that sth cares about.
+// Fields holds every key for callers that need more.
+type OSRelease struct {
+	ID        string
+	IDLike    []string
+	VersionID string
+	Codename  string
+	Fields    map[string]string
+}
+
+// ReadOSRelease parses /etc/os-release (falling back to /usr/lib/os-release).
+// Missing files yield an empty OSRelease.
+func ReadOSRelease() OSRelease {
+	fields, ok := parseEnvFile(osReleasePath)
+	if !ok {
+		fields, _ = parseEnvFile(osReleaseFallback)
+	}
+	return newOSRelease(fields)
+}
+
+func newOSRelease(fields map[string]string) OSRelease {
+	rel := OSRelease{Fields: fields}
+	if fields == nil {
+		return rel
+	}
+	rel.ID = Normalize(fields["ID"])
+	rel.IDLike = strings.Fields(Normalize(fields["ID_LIKE"]))
+	rel.VersionID = strings.TrimSpace(fields["VERSION_ID"])
+	// Ubuntu sets both; older releases only have UBUNTU_CODENAME
+	rel.Codename = Normalize(fields["VERSION_CODENAME"])
+	if rel.Codename == "" {
+		rel.Codename = Normalize(fields["UBUNTU_CODENAME"])
+	}
+	return rel
+}
+
 func DetectDistro() string {
 	// try to find distro in /etc/os-release
-	distro, found := findInFile("/etc/os-release", "ID=")
-	if found {
-		return distro
+	if rel := ReadOSRelease(); rel.ID != "" {
+		return rel.ID
 	}
 
 	// try to find distro in /etc/lsb-release
-	distro, found = findInFile("/etc/lsb-release", "DISTRIB_ID=")
+	distro, found := findInFile(lsbReleasePath, "DISTRIB_ID=")
 	if found {
 		return distro
 	}
@@ -75,8 +121,23 @@ func DetectArch() string {
 	return runtime.GOARCH
 }
 
-func DetectFamily(id string) string {
-	id = Normalize(string(id))
+// DetectFamily maps a distro ID to its family. When the ID itself is unknown,
+// the ID_LIKE values are tried in order, e.g. "tuxedo" with ID_LIKE="ubuntu"
+// resolves to debian.
+func DetectFamily(id string, like ...string) string {
+	if f := familyOf(id); f != FamilyOther {
+		return f
+	}
+	for _, l := range like {
+		if f := familyOf(l); f != FamilyOther {
+			return f
+		}
+	}
+	return FamilyOther
+}
+
+func familyOf(id string) string {
+	id = Normalize(id)
 	if _, ok := debianIDs[id]; ok {
 		return FamilyDebian
 	}
@@ -89,6 +150,38 @@ func DetectFamily(id string) string {
 	return FamilyOther
 }
 
+// parseEnvFile reads a KEY=value file as used by os-release and lsb-release.
+// Quotes around values are stripped; comments and blank lines are skipped.
+func parseEnvFile(path string) (map[string]string, bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, false
+	}
+	defer file.Close()
+
+	fields := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, val, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		val = strings.TrimSpace(val)
+		if len(val) >= 2 && (val[0] == '"' || val[0] == '\'') && val[len(val)-1] == val[0] {
+			val = val[1 : len(val)-1]
+		}
+		fields[strings.TrimSpace(key)] = val
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, false
+	}
+	return fields, true
+}
+
 func findInFile(path string, needle string) (string, bool) {
 	file, err := os.Open(path)
 	if err != nil {
@@ -0,0 +1,252 @@
+package recipes
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aottr/sth/internal"
+	"github.com/aottr/sth/internal/installer"
+	"github.com/aottr/sth/internal/platform"
+	"github.com/aottr/sth/internal/sthpkgs"
+)
+
+// Driver installs the recipes section of packages.yml. Artifact recipes from
+// the sthpkgs index are preferred; names only found in the legacy index run
+// their shell steps.
+type Driver struct {
+	// Offline plans from the manifests and PATH alone, without fetching
+	// indexes or resolving versions
+	Offline bool
+
+	index *sthpkgs.RecipeIndex
+}
+
+func New() *Driver { return &Driver{} }
+
+func (d *Driver) Name() string { return string(internal.PackageTypeRecipe) }
+
+func (d *Driver) Detect() bool { return true }
+
+// Installed lists the artifact manifests of the user scope
+func (d *Driver) Installed(ctx context.Context) ([]installer.Package, error) {
+	manifests, err := sthpkgs.ListManifests(sthpkgs.DefaultPaths(sthpkgs.InstallScopeUser))
+	if err != nil {
+		return nil, err
+	}
+	pkgs := make([]installer.Package, 0, len(manifests))
+	for _, m := range manifests {
+		pkgs = append(pkgs, installer.Package{Name: m.Key(), Version: m.Version})
+	}
+	return pkgs, nil
+}
+
+// Plan resolves every recipe, which may query version sources, and compares
+// the result with the recorded manifest
+func (d *Driver) Plan(ctx context.Context, desired *internal.Packages) ([]installer.Change, error) {
+	if len(desired.Recipes) == 0 {
+		return nil, nil
+	}
+	var changes []installer.Change
+	for _, name := range desired.Recipes {
+		c, err := d.planOne(ctx, name)
+		if err != nil {
+			return nil, fmt.Errorf("recipe '%s': %w", name, err)
+		}
+		changes = append(changes, c)
+	}
+	return changes, nil
+}
+
+func (d *Driver) planOne(ctx context.Context, name string) (installer.Change, error) {
+	c := installer.Change{Manager: d.Name(), Name: name}
+	if d.Offline {
+		return d.planOffline(name)
+	}
+
+	rr, found, err := d.resolve(ctx, name)
+	if err != nil {
+		return c, err
+	}
+	if !found {
+		return d.planLegacy(name)
+	}
+
+	c.To = rr.Resolved.Version
+	c.Spec = rr
+	m, err := sthpkgs.ReadManifest(rr.Paths, sthpkgs.ManifestKey(rr))
+	if err != nil {
+		return c, err
+	}
+	switch {
+	case m == nil:
+		c.Action = installer.ActionInstall
+	case m.Version != rr.Resolved.Version:
+		c.Action = installer.ActionUpgrade
+		c.From = m.Version
+	case !m.Linked():
+		c.Action = installer.ActionInstall
+		c.From = m.Version
+		c.Reason = "binary or symlink missing"
+	default:
+		c.Action = installer.ActionSkip
+		c.From = m.Version
+		c.Reason = "installed"
+		return c, nil
+	}
+	for _, a := range rr.Actions {
+		c.Details = append(c.Details, a.String())
+	}
+	return c, nil
+}
+
+// resolve looks name up in the artifact index and resolves its recipe
+func (d *Driver) resolve(ctx context.Context, name string) (sthpkgs.ResolveResult, bool, error) {
+	if d.index == nil {
+		idx, err := sthpkgs.FetchRecipeIndex()
+		if err != nil {
+			return sthpkgs.ResolveResult{}, false, err
+		}
+		d.index = idx
+	}
+	entry, ok := sthpkgs.FindIndexEntry(d.index, name, platform.GetPlatformInfo())
+	if !ok {
+		return sthpkgs.ResolveResult{}, false, nil
+	}
+	recipe, err := sthpkgs.FetchPackageRecipe(entry.Path)
+	if err != nil {
+		return sthpkgs.ResolveResult{}, false, err
+	}
+	rr, err := sthpkgs.ResolveRecipe(ctx, *recipe)
+	if err != nil {
+		return sthpkgs.ResolveResult{}, false, err
+	}
+	return rr, true, nil
+}
+
+func (d *Driver) planLegacy(name string) (installer.Change, error) {
+	c := installer.Change{Manager: d.Name(), Name: name}
+	rr, err := FindRecipe(name)
+	if err != nil {
+		return c, err
+	}
+	recipe, err := FetchRecipe(*rr)
+	if err != nil {
+		return c, err
+	}
+	c.Spec = recipe
+	if IsInstalled(name) {
+		c.Action = installer.ActionSkip
+		c.Reason = "found on PATH"
+		return c, nil
+	}
+	c.Action = installer.ActionInstall
+	for _, step := range recipe.Steps {
+		c.Details = append(c.Details, "shell "+strings.TrimSpace(step))
+	}
+	return c, nil
+}
+
+// planOffline reports a recipe as installed when its manifest is intact or,
+// for legacy recipes, when it is found on PATH
+func (d *Driver) planOffline(name string) (installer.Change, error) {
+	c := installer.Change{Manager: d.Name(), Name: name}
+	manifests, err := sthpkgs.ListManifests(sthpkgs.DefaultPaths(sthpkgs.InstallScopeUser))
+	if err != nil {
+		return c, err
+	}
+	for _, m := range manifests {
+		if m.Key() != name && m.Name != name {
+			continue
+		}
+		c.From = m.Version
+		c.Spec = m
+		if m.Linked() {
+			c.Action = installer.ActionSkip
+			c.Reason = "installed"
+		} else {
+			c.Action = installer.ActionInstall
+			c.Reason = "binary or symlink missing"
+		}
+		return c, nil
+	}
+	if IsInstalled(name) {
+		c.Action = installer.ActionSkip
+		c.Reason = "found on PATH"
+		return c, nil
+	}
+	c.Action = installer.ActionInstall
+	return c, nil
+}
+
+// Drift plans the removal of artifacts whose manifest matches no declared recipe
+func (d *Driver) Drift(ctx context.Context, desired *internal.Packages) ([]installer.Change, error) {
+	manifests, err := sthpkgs.ListManifests(sthpkgs.DefaultPaths(sthpkgs.InstallScopeUser))
+	if err != nil {
+		return nil, err
+	}
+	declared := make(map[string]struct{}, len(desired.Recipes))
+	for _, name := range desired.Recipes {
+		declared[name] = struct{}{}
+	}
+	var changes []installer.Change
+	for _, m := range manifests {
+		if _, ok := declared[m.Key()]; ok {
+			continue
+		}
+		if _, ok := declared[m.Name]; ok {
+			continue
+		}
+		changes = append(changes, installer.Change{
+			Manager: d.Name(),
+			Action:  installer.ActionRemove,
+			Name:    m.Key(),
+			From:    m.Version,
+			Reason:  "not declared",
+			Details: append([]string{"remove " + m.InstallDir}, m.Links...),
+			Spec:    m,
+		})
+	}
+	return changes, nil
+}
+
+// Removals skips the removal of recipes without a manifest, i.e. legacy
+// recipes found on PATH, as sth does not know what their steps installed
+func (d *Driver) Removals(ctx context.Context, plan []installer.Change) ([]installer.Change, error) {
+	for i, c := range plan {
+		if c.Action != installer.ActionRemove {
+			continue
+		}
+		if _, ok := c.Spec.(sthpkgs.Manifest); !ok {
+			plan[i].Action = installer.ActionSkip
+			plan[i].Reason = "installed by shell steps, remove it by hand"
+		}
+	}
+	return plan, nil
+}
+
+// Apply runs the planned recipes one after another and uninstalls removed
+// artifacts
+func (d *Driver) Apply(ctx context.Context, plan []installer.Change) error {
+	for _, c := range installer.ByAction(plan, installer.ActionRemove) {
+		if m, ok := c.Spec.(sthpkgs.Manifest); ok {
+			fmt.Printf("🗑️ Removing %s %s\n", c.Name, m.Version)
+			if err := m.Uninstall(sthpkgs.DefaultPaths(sthpkgs.InstallScopeUser)); err != nil {
+				return fmt.Errorf("recipe '%s': %w", c.Name, err)
+			}
+		}
+	}
+	for _, c := range installer.ByAction(plan, installer.ActionInstall, installer.ActionUpgrade) {
+		switch spec := c.Spec.(type) {
+		case sthpkgs.ResolveResult:
+			if err := sthpkgs.ExecuteResolved(ctx, spec); err != nil {
+				return fmt.Errorf("recipe '%s' failed: %w", c.Name, err)
+			}
+		case *internal.Recipe:
+			if err := RunRecipe(c.Name, spec); err != nil {
+				return fmt.Errorf("recipe '%s' failed: %w", c.Name, err)
+			}
+		}
+	}
+	return nil
+}
@@ -3,13 +3,13 @@ package recipes
 import (
 	"fmt"
 	"io"
-	"net/http"
 	"os/exec"
 	"strings"
 	"time"
 
 	"github.com/aottr/sth/internal"
 	"github.com/aottr/sth/internal/cache"
+	"github.com/aottr/sth/internal/cassette"
 	"github.com/aottr/sth/internal/utils"
 	"gopkg.in/yaml.v3"
 )
@@ -17,9 +17,12 @@ import (
 const RecipeIndex = "https://raw.githubusercontent.com/aottr/sthpkgs/refs/heads/main/index.yml"
 const RecipesBase = "https://raw.githubusercontent.com/aottr/sthpkgs/refs/heads/main/"
 
+// fetchTimeout bounds fetching a recipe or the index
+const fetchTimeout = 30 * time.Second
+
 func FetchRecipe(entry internal.RecipeIndexEntry) (*internal.Recipe, error) {
 	fmt.Printf("🌐 Downloading recipe for %s\n", entry.Name)
-	resp, err := http.Get(RecipesBase + entry.Path)
+	resp, err := cassette.Client(fetchTimeout).Get(RecipesBase + entry.Path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to GET recipe: %w", err)
 	}
@@ -57,7 +60,7 @@ func IsInstalled(pkg string) bool {
 
 func fetchRecipeIndex(url string) (*internal.RecipeIndex, error) {
 	fmt.Printf("🌐 Downloading recipe index from %s\n", url)
-	resp, err := http.Get(url)
+	resp, err := cassette.Client(fetchTimeout).Get(url)
 	if err != nil {
 		return nil, fmt.Errorf("failed to GET recipe index: %w", err)
 	}
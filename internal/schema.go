@@ -0,0 +1,69 @@
+package internal
+
+import (
+	"reflect"
+
+	"github.com/aottr/sth/internal/schema"
+)
+
+// PackagesSchemaID is where the packages.yml schema is published
+const PackagesSchemaID = "https://raw.githubusercontent.com/aottr/sth/main/schemas/packages.schema.json"
+
+// PackagesSchema returns the JSON Schema of packages.yml
+func PackagesSchema() ([]byte, error) {
+	str := schema.Schema{"type": "string"}
+	when := schema.Schema{"type": "string", "description": "condition on the platform, e.g. family == debian && version >= 22.04"}
+	named := schema.Schema{"type": "string", "description": "name"}
+	versions := schema.Schema{
+		"type": "object",
+		"additionalProperties": schema.Schema{"anyOf": []any{
+			schema.Schema{"type": "string", "description": `"latest" or a version`},
+			schema.Schema{
+				"type":                 "object",
+				"properties":           schema.Schema{"version": str, "when": when},
+				"additionalProperties": false,
+			},
+		}},
+	}
+	withWhen := map[string]schema.Schema{"when": when}
+
+	g := &schema.Generator{
+		Alternatives: map[reflect.Type][]schema.Schema{
+			reflect.TypeOf(FlatpakRef{}):  {named},
+			reflect.TypeOf(BrewTap{}):     {named},
+			reflect.TypeOf(BrewFormula{}): {named},
+			reflect.TypeOf(BrewCask{}):    {named},
+			reflect.TypeOf(SnapPackage{}): {named},
+			reflect.TypeOf(Include{}):     {schema.Schema{"type": "string", "description": "path relative to this file"}},
+			reflect.TypeOf(Tool{}):        {schema.Schema{"type": "null"}},
+			reflect.TypeOf(Brew{}):        {schema.Schema{"type": "array", "items": schema.Schema{"$ref": "#/$defs/BrewFormula"}}},
+		},
+		Properties: map[reflect.Type]map[string]schema.Schema{
+			reflect.TypeOf(FlatpakRef{}):    withWhen,
+			reflect.TypeOf(BrewTap{}):       withWhen,
+			reflect.TypeOf(BrewFormula{}):   withWhen,
+			reflect.TypeOf(BrewCask{}):      withWhen,
+			reflect.TypeOf(SnapPackage{}):   withWhen,
+			reflect.TypeOf(AptRepository{}): withWhen,
+			reflect.TypeOf(FlatpakRemote{}): withWhen,
+		},
+		Fields: map[reflect.Type]map[string]schema.Schema{
+			reflect.TypeOf(Sections{}): {
+				"apt":   versions,
+				"pipx":  versions,
+				"cargo": versions,
+				"go":    versions,
+				"npm":   versions,
+				"recipes": schema.Schema{"type": "array", "items": schema.Schema{"anyOf": []any{
+					named,
+					schema.Schema{
+						"type":                 "object",
+						"properties":           schema.Schema{"name": str, "when": when},
+						"additionalProperties": false,
+					},
+				}}},
+			},
+		},
+	}
+	return g.Generate(Packages{}, PackagesSchemaID, "sth packages.yml")
+}
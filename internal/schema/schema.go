@@ -0,0 +1,134 @@
+// Package schema generates JSON Schemas for the YAML files of sth from the
+// Go types they decode into, so editors can complete and check them.
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// Schema is a JSON Schema object
+type Schema = map[string]any
+
+// Generator builds a schema from struct types and their yaml tags. Types
+// with their own UnmarshalYAML accept more than their fields; their other
+// forms are given as Alternatives.
+type Generator struct {
+	// Alternatives are other ways to write a type, e.g. a plain string for
+	// a mapping with a name
+	Alternatives map[reflect.Type][]Schema
+	// Properties are extra keys of a struct type that are handled before
+	// decoding, e.g. when
+	Properties map[reflect.Type]map[string]Schema
+	// Fields replace the schema of a struct field, by yaml key
+	Fields map[reflect.Type]map[string]Schema
+
+	defs Schema
+}
+
+// Generate returns the indented schema of v with its named structs under $defs
+func (g *Generator) Generate(v any, id, title string) ([]byte, error) {
+	g.defs = Schema{}
+	root := g.schemaOf(reflect.TypeOf(v))
+	root["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	root["$id"] = id
+	root["title"] = title
+	root["$defs"] = g.defs
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(root); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (g *Generator) schemaOf(t reflect.Type) Schema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		return g.object(t)
+	case reflect.Map:
+		return Schema{"type": "object", "additionalProperties": g.ref(t.Elem())}
+	case reflect.Slice, reflect.Array:
+		return Schema{"type": "array", "items": g.ref(t.Elem())}
+	case reflect.String:
+		return Schema{"type": "string"}
+	case reflect.Bool:
+		return Schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return Schema{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return Schema{"type": "number"}
+	}
+	return Schema{}
+}
+
+// ref returns a $ref for named structs, defining them on first use
+func (g *Generator) ref(t reflect.Type) Schema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || t.Name() == "" {
+		return g.schemaOf(t)
+	}
+	if _, ok := g.defs[t.Name()]; !ok {
+		g.defs[t.Name()] = Schema{} // placeholder for recursive types
+		g.defs[t.Name()] = g.object(t)
+	}
+	return Schema{"$ref": "#/$defs/" + t.Name()}
+}
+
+func (g *Generator) object(t reflect.Type) Schema {
+	props := Schema{}
+	var additional any = false
+	g.fields(t, props, &additional)
+	for k, v := range g.Properties[t] {
+		props[k] = v
+	}
+	s := Schema{"type": "object", "properties": props, "additionalProperties": additional}
+	if alts := g.Alternatives[t]; len(alts) > 0 {
+		anyOf := append([]any{}, s)
+		for _, a := range alts {
+			anyOf = append(anyOf, a)
+		}
+		return Schema{"anyOf": anyOf}
+	}
+	return s
+}
+
+func (g *Generator) fields(t reflect.Type, props Schema, additional *any) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if name == "-" {
+			continue
+		}
+		if strings.Contains(opts, "inline") {
+			switch f.Type.Kind() {
+			case reflect.Struct:
+				g.fields(f.Type, props, additional)
+			case reflect.Map:
+				*additional = g.ref(f.Type.Elem())
+			}
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		if override, ok := g.Fields[t][name]; ok {
+			props[name] = override
+			continue
+		}
+		props[name] = g.ref(f.Type)
+	}
+}
@@ -0,0 +1,169 @@
+package snap
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/aottr/sth/internal"
+	"github.com/aottr/sth/internal/installer"
+)
+
+// InstalledSnap is one row of `snap list`
+type InstalledSnap struct {
+	Name     string
+	Version  string
+	Revision string
+	Tracking string
+	Notes    string
+}
+
+// Installed lists installed snaps keyed by name
+func Installed(ctx context.Context) (map[string]InstalledSnap, error) {
+	cmd := exec.CommandContext(ctx, "snap", "list", "--unicode=never", "--color=never")
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("snap list failed: %w", err)
+	}
+	installed := make(map[string]InstalledSnap)
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	// Name  Version  Rev  Tracking  Publisher  Notes
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		if i == 0 || len(fields) < 4 {
+			continue
+		}
+		s := InstalledSnap{
+			Name:     fields[0],
+			Version:  fields[1],
+			Revision: fields[2],
+			Tracking: fields[3],
+		}
+		if len(fields) > 5 {
+			s.Notes = fields[5]
+		}
+		installed[s.Name] = s
+	}
+	return installed, nil
+}
+
+// Driver installs snaps. snap cannot apply per-package options in one call,
+// so each snap is handled on its own.
+type Driver struct{}
+
+func New() *Driver { return &Driver{} }
+
+func (d *Driver) Name() string { return string(internal.PackageTypeSnap) }
+
+func (d *Driver) Detect() bool {
+	_, err := exec.LookPath("snap")
+	return err == nil
+}
+
+func (d *Driver) Installed(ctx context.Context) ([]installer.Package, error) {
+	installed, err := Installed(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pkgs := make([]installer.Package, 0, len(installed))
+	for _, s := range installed {
+		pkgs = append(pkgs, installer.Package{Name: s.Name, Version: s.Version})
+	}
+	sort.Slice(pkgs, func(i, j int) bool { return pkgs[i].Name < pkgs[j].Name })
+	return pkgs, nil
+}
+
+// Plan installs missing snaps and moves pinned snaps to their revision
+func (d *Driver) Plan(ctx context.Context, desired *internal.Packages) ([]installer.Change, error) {
+	if len(desired.Snap) == 0 {
+		return nil, nil
+	}
+	if !d.Detect() {
+		names := make([]string, 0, len(desired.Snap))
+		for _, p := range desired.Snap {
+			names = append(names, p.Name)
+		}
+		return installer.SkipAll(d.Name(), names, "snap not available"), nil
+	}
+	installed, err := Installed(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var changes []installer.Change
+	for _, p := range desired.Snap {
+		if strings.TrimSpace(p.Name) == "" {
+			continue
+		}
+		c := installer.Change{Manager: d.Name(), Name: p.Name, To: p.Revision, Spec: p}
+		cur, ok := installed[p.Name]
+		switch {
+		case !ok:
+			c.Action = installer.ActionInstall
+			c.Details = snapArgs(p)[1:]
+		case p.Revision != "" && cur.Revision != p.Revision:
+			c.Action = installer.ActionUpgrade
+			c.From = cur.Revision
+		case strings.Contains(cur.Notes, "held"):
+			c.Action = installer.ActionHold
+			c.From = cur.Revision
+			c.Reason = "refresh held"
+		default:
+			c.Action = installer.ActionSkip
+			c.From = cur.Version
+			c.Reason = "installed"
+		}
+		changes = append(changes, c)
+	}
+	return changes, nil
+}
+
+func (d *Driver) Apply(ctx context.Context, plan []installer.Change) error {
+	for _, c := range plan {
+		p, _ := c.Spec.(internal.SnapPackage)
+		switch c.Action {
+		case installer.ActionInstall:
+			fmt.Printf("📦 Installing snap: %s\n", p.Name)
+			if err := run(ctx, append([]string{"install"}, snapArgs(p)...)...); err != nil {
+				return fmt.Errorf("snap install %s failed: %w", p.Name, err)
+			}
+		case installer.ActionUpgrade:
+			fmt.Printf("📌 Refreshing snap %s to revision %s\n", p.Name, p.Revision)
+			if err := run(ctx, "refresh", p.Name, "--revision="+p.Revision); err != nil {
+				return fmt.Errorf("snap refresh %s failed: %w", p.Name, err)
+			}
+		}
+	}
+	return Remove(ctx, installer.Names(installer.ByAction(plan, installer.ActionRemove)))
+}
+
+// Remove uninstalls the named snaps
+func Remove(ctx context.Context, names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	fmt.Printf("🗑️ Removing snaps: %s\n", strings.Join(names, " "))
+	return run(ctx, append([]string{"remove"}, names...)...)
+}
+
+func snapArgs(p internal.SnapPackage) []string {
+	args := []string{p.Name}
+	if p.Channel != "" {
+		args = append(args, "--channel="+p.Channel)
+	}
+	if p.Classic {
+		args = append(args, "--classic")
+	}
+	if p.Revision != "" {
+		args = append(args, "--revision="+p.Revision)
+	}
+	return args
+}
+
+func run(ctx context.Context, args ...string) error {
+	cmd := exec.CommandContext(ctx, "sudo", append([]string{"snap"}, args...)...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	return cmd.Run()
+}
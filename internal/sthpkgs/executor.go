@@ -16,6 +16,13 @@ import (
 )
 
 func ExecuteResolved(ctx context.Context, rr ResolveResult) error {
+	if utils.GetExecOptions(ctx).DryRun {
+		for _, a := range rr.Actions {
+			fmt.Printf("[sth] (dry-run) %s\n", a)
+		}
+		return nil
+	}
+
 	// ensure all directories
 	if err := os.MkdirAll(rr.Paths.CacheDir, 0o755); err != nil {
 		return fmt.Errorf("cache dir: %w", err)
@@ -33,7 +40,7 @@ func ExecuteResolved(ctx context.Context, rr ResolveResult) error {
 		if st, err := os.Stat(rr.Resolved.BinaryPath); err == nil && (st.Mode()&0o111) != 0 {
 			fmt.Printf("[sth] ✅ already installed: %s -> %s\n", link, rr.Resolved.BinaryPath)
 			checkPathHint(ctx, rr.Paths.BinDir)
-			return nil
+			return writeManifest(rr)
 		}
 	}
 
@@ -43,6 +50,9 @@ func ExecuteResolved(ctx context.Context, rr ResolveResult) error {
 			return fmt.Errorf("%s: %w", a.Type, err)
 		}
 	}
+	if err := writeManifest(rr); err != nil {
+		return fmt.Errorf("manifest: %w", err)
+	}
 	checkPathHint(ctx, rr.Paths.BinDir)
 	return nil
 }
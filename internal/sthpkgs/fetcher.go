@@ -8,8 +8,27 @@ import (
 	"os"
 	"strings"
 	"time"
+
+	"github.com/aottr/sth/internal/cassette"
 )
 
+type transportKey struct{}
+
+// WithTransport sends the requests of recipe resolution and downloads made
+// with ctx through rt, e.g. to a fixture server
+func WithTransport(ctx context.Context, rt http.RoundTripper) context.Context {
+	return context.WithValue(ctx, transportKey{}, rt)
+}
+
+// httpClient returns a client using the transport of ctx, if any, and the
+// STH_HTTP_MODE transport otherwise
+func httpClient(ctx context.Context, timeout time.Duration) *http.Client {
+	if rt, ok := ctx.Value(transportKey{}).(http.RoundTripper); ok {
+		return &http.Client{Timeout: timeout, Transport: rt}
+	}
+	return cassette.Client(timeout)
+}
+
 func downloadToFile(ctx context.Context, url, destPath string) error {
 	if url == "" {
 		return fmt.Errorf("downloadToFile: empty url")
@@ -23,7 +42,7 @@ func downloadToFile(ctx context.Context, url, destPath string) error {
 		return fmt.Errorf("downloadToFile: request: %w", err)
 	}
 	req.Header.Set("User-Agent", "sth-installer/1.0")
-	client := &http.Client{Timeout: 60 * time.Second}
+	client := httpClient(ctx, 60*time.Second)
 
 	resp, err := client.Do(req)
 	if err != nil {
@@ -63,7 +82,7 @@ type ghRelease struct {
 	Prerelease bool   `json:"prerelease"` // true/false
 }
 
-func githubClient() *http.Client { return &http.Client{Timeout: 15 * time.Second} }
+func githubClient(ctx context.Context) *http.Client { return httpClient(ctx, 15*time.Second) }
 
 func githubReq(ctx context.Context, url string) (*http.Request, error) {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
@@ -0,0 +1,290 @@
+package sthpkgs
+
+import (
+	"bytes"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// formatIndent is the indentation of formatted recipes
+const formatIndent = 2
+
+// normalizedLists are the target lists that are lowercased, deduplicated
+// and sorted, like loadRecipe does
+var normalizedLists = []string{"os", "arch", "codename"}
+
+// blankMarker stands in for a blank line in a head comment while encoding
+const blankMarker = "#sthpkgs:format:blank"
+
+// FormatRecipe returns a recipe in canonical form: keys in the order of the
+// Recipe fields, normalized target lists, quotes only where YAML needs them
+// and two-space indentation. Comments and a blank line before a key or
+// list entry are kept with it.
+func FormatRecipe(data []byte) ([]byte, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	if root.Kind == 0 {
+		return data, nil
+	}
+	markBlankLines(&root, strings.Split(string(data), "\n"))
+	formatNode(&root, reflect.TypeOf(Recipe{}))
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(formatIndent)
+	if err := enc.Encode(&root); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return restoreBlankLines(buf.Bytes()), nil
+}
+
+// markBlankLines puts blankMarker on top of the head comment of every
+// mapping key and block list entry that follows a blank line in lines
+func markBlankLines(node *yaml.Node, lines []string) {
+	mark := func(n *yaml.Node) {
+		first := n.Line - 1
+		if n.HeadComment != "" {
+			first -= strings.Count(n.HeadComment, "\n") + 1
+		}
+		if first > 0 && first <= len(lines) && strings.TrimSpace(lines[first-1]) == "" {
+			n.HeadComment = joinComments(blankMarker, n.HeadComment)
+		}
+	}
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			mark(node.Content[i])
+		}
+	case yaml.SequenceNode:
+		if node.Style&yaml.FlowStyle == 0 {
+			for _, item := range node.Content {
+				if item.Kind != yaml.ScalarNode {
+					mark(item)
+				}
+			}
+		}
+	}
+	for _, c := range node.Content {
+		markBlankLines(c, lines)
+	}
+}
+
+// restoreBlankLines turns blankMarker lines into blank lines, except at the
+// top and after another blank line
+func restoreBlankLines(data []byte) []byte {
+	lines := strings.SplitAfter(string(data), "\n")
+	out := lines[:0]
+	for _, l := range lines {
+		if strings.TrimSpace(l) == blankMarker {
+			if len(out) == 0 || strings.TrimSpace(out[len(out)-1]) == "" {
+				continue
+			}
+			l = "\n"
+		}
+		out = append(out, l)
+	}
+	return []byte(strings.Join(out, ""))
+}
+
+func formatNode(node *yaml.Node, t reflect.Type) {
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, c := range node.Content {
+			formatNode(c, t)
+		}
+	case yaml.ScalarNode:
+		// strings are plain unless they would read as something else, e.g.
+		// "0755" or "{{.Version}}", and then double-quoted
+		if node.Tag == "!!str" && node.Style != yaml.LiteralStyle && node.Style != yaml.FoldedStyle {
+			node.Style = 0
+			if needsQuotes(node.Value) {
+				node.Style = yaml.DoubleQuotedStyle
+			}
+		}
+	case yaml.SequenceNode:
+		node.Style = 0
+		var elem reflect.Type
+		if t != nil && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
+			elem = t.Elem()
+		}
+		for _, c := range node.Content {
+			formatNode(c, elem)
+		}
+	case yaml.MappingNode:
+		node.Style = 0
+		var order []string
+		types := map[string]reflect.Type{}
+		switch {
+		case t == nil:
+		case t.Kind() == reflect.Struct:
+			order, types = fieldOrder(t)
+		case t.Kind() == reflect.Map:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				types[node.Content[i].Value] = t.Elem()
+			}
+		}
+		if t == reflect.TypeOf(InstallAction{}) {
+			if args := mappingValue(node, "args"); args != nil {
+				if spec, ok := actionArgs[scalarValue(mappingValue(node, "type"))]; ok {
+					sortPairs(args, append(slices.Clone(spec.required), spec.optional...))
+				} else {
+					sortPairs(args, nil)
+				}
+			}
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			formatNode(node.Content[i+1], types[node.Content[i].Value])
+		}
+		if t == reflect.TypeOf(Target{}) {
+			for _, key := range normalizedLists {
+				normalizeList(mappingValue(node, key))
+			}
+		}
+		if order != nil {
+			// a comment above the first key heads the file or block and stays on top
+			header := ""
+			if len(node.Content) > 0 {
+				header, node.Content[0].HeadComment = node.Content[0].HeadComment, ""
+			}
+			sortPairs(node, order)
+			if len(node.Content) > 0 {
+				node.Content[0].HeadComment = joinComments(header, node.Content[0].HeadComment)
+			}
+		}
+	}
+}
+
+// fieldOrder returns the YAML keys of struct t in declaration order, and
+// their types
+func fieldOrder(t reflect.Type) ([]string, map[string]reflect.Type) {
+	var order []string
+	types := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if !f.IsExported() || name == "-" || name == "" {
+			continue
+		}
+		order = append(order, name)
+		types[name] = f.Type
+	}
+	return order, types
+}
+
+// sortPairs orders the pairs of a mapping by their position in order. Keys
+// not in order follow, alphabetically when order is nil and as they were
+// otherwise.
+func sortPairs(m *yaml.Node, order []string) {
+	if m == nil || m.Kind != yaml.MappingNode {
+		return
+	}
+	type pair struct{ key, value *yaml.Node }
+	pairs := make([]pair, 0, len(m.Content)/2)
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		pairs = append(pairs, pair{m.Content[i], m.Content[i+1]})
+	}
+	rank := func(key string) int {
+		if i := slices.Index(order, key); i >= 0 {
+			return i
+		}
+		return len(order)
+	}
+	sort.SliceStable(pairs, func(i, j int) bool {
+		ri, rj := rank(pairs[i].key.Value), rank(pairs[j].key.Value)
+		if ri == rj && ri == len(order) && order == nil {
+			return pairs[i].key.Value < pairs[j].key.Value
+		}
+		return ri < rj
+	})
+	m.Content = m.Content[:0]
+	for _, p := range pairs {
+		m.Content = append(m.Content, p.key, p.value)
+	}
+}
+
+// normalizeList lowercases, deduplicates and sorts a list of scalars and
+// writes it in flow style, e.g. [amd64, arm64], unless entries have comments
+func normalizeList(list *yaml.Node) {
+	if list == nil || list.Kind != yaml.SequenceNode {
+		return
+	}
+	for _, item := range list.Content {
+		if item.Kind != yaml.ScalarNode {
+			return
+		}
+	}
+	seen := map[string]*yaml.Node{}
+	var items []*yaml.Node
+	var orphaned []string
+	for _, item := range list.Content {
+		item.Value = strings.ToLower(strings.TrimSpace(item.Value))
+		comments := []string{item.HeadComment, item.LineComment, item.FootComment}
+		if kept, ok := seen[item.Value]; ok {
+			// a dropped duplicate leaves its comments with the entry kept
+			kept.HeadComment = joinComments(kept.HeadComment, comments[0])
+			kept.LineComment = strings.TrimSpace(kept.LineComment + " " + comments[1])
+			kept.FootComment = joinComments(kept.FootComment, comments[2])
+			continue
+		}
+		if item.Value == "" {
+			orphaned = append(orphaned, comments...)
+			continue
+		}
+		seen[item.Value] = item
+		items = append(items, item)
+	}
+	for _, c := range orphaned {
+		list.HeadComment = joinComments(list.HeadComment, c)
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].Value < items[j].Value })
+	list.Content = items
+	// comments only read well on their own lines
+	list.Style = yaml.FlowStyle
+	if slices.ContainsFunc(items, func(n *yaml.Node) bool { return n.HeadComment+n.LineComment+n.FootComment != "" }) {
+		list.Style = 0
+	}
+}
+
+func mappingValue(m *yaml.Node, key string) *yaml.Node {
+	if m == nil || m.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func scalarValue(n *yaml.Node) string {
+	if n == nil {
+		return ""
+	}
+	return n.Value
+}
+
+// needsQuotes reports whether s cannot be written as a plain scalar
+func needsQuotes(s string) bool {
+	out, err := yaml.Marshal(s)
+	return err != nil || len(out) > 0 && (out[0] == '"' || out[0] == '\'')
+}
+
+func joinComments(a, b string) string {
+	if a == "" || b == "" {
+		return a + b
+	}
+	return a + "\n" + b
+}
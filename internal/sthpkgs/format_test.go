@@ -0,0 +1,95 @@
+package sthpkgs
+
+import "testing"
+
+func TestFormatRecipe(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{
+			name: "key order and quotes",
+			in:   "slug: 'fd'\nname: \"fd\"\n",
+			want: "name: fd\nslug: fd\n",
+		},
+		{
+			name: "blank lines stay with the next key",
+			in: `# fd recipe
+name: fd
+
+# where to get it
+source:
+  type: github
+  repo: sharkdp/fd   # upstream
+
+version:
+  type: github-release
+`,
+			want: `# fd recipe
+name: fd
+
+# where to get it
+source:
+  type: github
+  repo: sharkdp/fd # upstream
+
+version:
+  type: github-release
+`,
+		},
+		{
+			name: "blank lines travel with reordered keys and collapse",
+			in:   "slug: fd\n\n\n\nname: fd\n",
+			want: "name: fd\nslug: fd\n",
+		},
+		{
+			name: "blank line between list entries",
+			in: `name: fd
+install:
+  - type: symlink
+    args:
+      to: fd
+
+  # then
+  - type: chmod
+    args:
+      path: fd
+`,
+			want: `name: fd
+install:
+  - type: symlink
+    args:
+      to: fd
+
+  # then
+  - type: chmod
+    args:
+      path: fd
+`,
+		},
+		{
+			name: "target lists",
+			in:   "name: fd\ntarget:\n  os: [linux, Darwin, linux]\n  arch:\n    - ARM64\n    - amd64\n",
+			want: "name: fd\ntarget:\n  os: [darwin, linux]\n  arch: [amd64, arm64]\n",
+		},
+		{
+			name: "comments of dropped duplicates",
+			in:   "name: fd\ntarget:\n  arch:\n    - amd64\n    - arm64 # apple silicon\n    # again\n    - ARM64 # twice\n",
+			want: "name: fd\ntarget:\n  arch:\n    - amd64\n    # again\n    - arm64 # apple silicon # twice\n",
+		},
+	}
+	for _, tt := range tests {
+		got, err := FormatRecipe([]byte(tt.in))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, got, tt.want)
+			continue
+		}
+		again, err := FormatRecipe(got)
+		if err != nil || string(again) != string(got) {
+			t.Errorf("%s: formatting is not idempotent, got\n%s", tt.name, again)
+		}
+	}
+}
@@ -0,0 +1,409 @@
+package sthpkgs
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/aottr/sth/internal/platform"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultTestVersion is the version the fixture server reports when the
+// recipe test does not set one
+const DefaultTestVersion = "1.0.0"
+
+// RecipeTestFile is the optional test spec next to a recipe.yml
+const RecipeTestFile = "test.yml"
+
+// RecipeTest is the test spec of a recipe. Without one, a recipe is tested
+// against a fake GitHub API and a synthetic archive with a script at the
+// binary's path.
+type RecipeTest struct {
+	// Version is what the fake GitHub API and version URLs report
+	Version string `yaml:"version,omitempty"`
+	// Responses are recorded responses, served instead of synthetic ones
+	Responses []FixtureResponse `yaml:"responses,omitempty"`
+	// Binaries are the names that must be linked in BinDir, default the
+	// artifact's binName
+	Binaries []string `yaml:"binaries,omitempty"`
+}
+
+// FixtureResponse is a recorded response for a URL. The URL may use the
+// artifact template variables, e.g. {{.Version}}.
+type FixtureResponse struct {
+	URL    string `yaml:"url"`
+	Status int    `yaml:"status,omitempty"` // default 200
+	Body   string `yaml:"body,omitempty"`
+	File   string `yaml:"file,omitempty"` // relative to the recipe's folder
+}
+
+// TestResult is a recipe that installed and linked its binaries
+type TestResult struct {
+	Version  string
+	Root     string   // the temporary RootDir
+	Binaries []string // linked binaries that were checked
+}
+
+// TestRecipeFile installs a recipe into a temporary root with every request
+// answered by an in-process fixture server, and checks that its binaries
+// exist, are executable and are linked. The root is removed unless keep.
+func TestRecipeFile(ctx context.Context, recipePath string, keep bool) (*TestResult, error) {
+	r, err := loadRecipe(recipePath)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range r.Actions {
+		if a.Type == "shell" {
+			return nil, fmt.Errorf("shell actions cannot run hermetically")
+		}
+	}
+	spec, err := loadRecipeTest(filepath.Join(filepath.Dir(recipePath), RecipeTestFile))
+	if err != nil {
+		return nil, err
+	}
+
+	fixtures, err := newFixtureServer(r, spec, filepath.Dir(recipePath), platform.GetPlatformInfo())
+	if err != nil {
+		return nil, err
+	}
+	defer fixtures.Close()
+
+	root, err := os.MkdirTemp("", "sthpkgs-test-*")
+	if err != nil {
+		return nil, err
+	}
+	if !keep {
+		defer os.RemoveAll(root)
+	}
+	r.Paths = Paths{RootDir: root}
+
+	ctx = WithTransport(ctx, fixtures.Transport())
+	rr, err := ResolveRecipe(ctx, r)
+	if err != nil {
+		return nil, fixtures.explain(err)
+	}
+	if err := checkConfined(rr.Actions, root); err != nil {
+		return nil, err
+	}
+	if err := ExecuteResolved(ctx, rr); err != nil {
+		return nil, fixtures.explain(err)
+	}
+
+	binaries := spec.Binaries
+	if len(binaries) == 0 && rr.Resolved.BinName != "" {
+		binaries = []string{rr.Resolved.BinName}
+	}
+	if err := checkInstalled(rr, binaries); err != nil {
+		return nil, err
+	}
+	return &TestResult{Version: rr.Resolved.Version, Root: root, Binaries: binaries}, nil
+}
+
+// actionPathArgs are the action arguments naming files
+var actionPathArgs = []string{"path", "src", "dest", "file"}
+
+// checkConfined fails unless every file the actions touch is below root, so
+// a test never writes to the real filesystem
+func checkConfined(actions []InstallAction, root string) error {
+	for i, a := range actions {
+		for _, arg := range actionPathArgs {
+			p, ok := a.Args[arg]
+			if !ok {
+				continue
+			}
+			rel, err := filepath.Rel(root, filepath.Clean(p))
+			if !filepath.IsAbs(p) || err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				return fmt.Errorf("actions[%d] %s: %s %q is outside the install root, use {{.InstallDir}}, {{.CacheFile}} or {{.BinDir}}", i, a.Type, arg, p)
+			}
+		}
+	}
+	return nil
+}
+
+func loadRecipeTest(path string) (RecipeTest, error) {
+	var spec RecipeTest
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return spec, nil
+	}
+	if err != nil {
+		return spec, err
+	}
+	if err := yaml.Unmarshal(data, &spec); err != nil {
+		return spec, fmt.Errorf("%s: %w", path, err)
+	}
+	return spec, nil
+}
+
+// checkInstalled fails unless the binary exists and is executable, and
+// every binary is linked to an executable in BinDir
+func checkInstalled(rr ResolveResult, binaries []string) error {
+	if rr.Resolved.BinaryPath != "" {
+		if err := checkExecutable(rr.Resolved.BinaryPath); err != nil {
+			return err
+		}
+	}
+	for _, name := range binaries {
+		link := filepath.Join(rr.Paths.BinDir, name)
+		st, err := os.Lstat(link)
+		if err != nil {
+			return fmt.Errorf("%s is not linked: %w", name, err)
+		}
+		if st.Mode()&os.ModeSymlink == 0 {
+			return fmt.Errorf("%s is not a symlink", link)
+		}
+		target, err := filepath.EvalSymlinks(link)
+		if err != nil {
+			return fmt.Errorf("%s is a broken link: %w", link, err)
+		}
+		if err := checkExecutable(target); err != nil {
+			return err
+		}
+	}
+	m, err := ReadManifest(rr.Paths, ManifestKey(rr))
+	if err != nil {
+		return err
+	}
+	if m == nil {
+		return fmt.Errorf("no manifest was written")
+	}
+	return nil
+}
+
+func checkExecutable(p string) error {
+	st, err := os.Stat(p)
+	if err != nil {
+		return fmt.Errorf("binary missing: %w", err)
+	}
+	if !st.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a file", p)
+	}
+	if st.Mode()&0o111 == 0 {
+		return fmt.Errorf("%s is not executable (%s)", p, st.Mode())
+	}
+	return nil
+}
+
+// githubAPI matches the GitHub API calls of the version sources
+var githubAPI = regexp.MustCompile(`^/repos/[^/]+/[^/]+/(releases/latest|releases|tags)$`)
+
+// fixtureServer answers requests for any host. The transport sends them to
+// the server with the original URL in a header.
+type fixtureServer struct {
+	*httptest.Server
+	version string
+	routes  map[string]fixture
+
+	mu     sync.Mutex
+	missed []string
+}
+
+type fixture struct {
+	status int
+	body   []byte
+}
+
+const originalURLHeader = "X-Sth-Original-Url"
+
+func newFixtureServer(r Recipe, spec RecipeTest, dir string, pi platform.Info) (*fixtureServer, error) {
+	vs := r.Artifact.Version
+	version := spec.Version
+	if version == "" && vs.Type == "static" {
+		version = vs.Value
+	}
+	if version == "" {
+		version = DefaultTestVersion
+	}
+	name := r.Artifact.Name
+	if name == "" {
+		name = r.Name
+	}
+	tctx := templateContext(name, version, pi)
+
+	fs := &fixtureServer{version: version, routes: map[string]fixture{}}
+	for _, resp := range spec.Responses {
+		u, err := renderTemplate(resp.URL, tctx)
+		if err != nil {
+			return nil, fmt.Errorf("fixture %s: %w", resp.URL, err)
+		}
+		body := []byte(resp.Body)
+		if resp.File != "" {
+			if body, err = os.ReadFile(filepath.Join(dir, resp.File)); err != nil {
+				return nil, fmt.Errorf("fixture %s: %w", resp.URL, err)
+			}
+		}
+		fs.routes[u] = fixture{status: resp.Status, body: body}
+	}
+
+	if vs.Type == "regex" && vs.URL != "" {
+		fs.addDefault(vs.URL, []byte(version))
+	}
+	if !r.Artifact.IsEmpty() {
+		artifactURL, err := renderTemplate(r.Artifact.URLTemplate, tctx)
+		if err != nil {
+			return nil, err
+		}
+		inner, err := renderTemplate(r.Artifact.InnerPath, tctx)
+		if err != nil {
+			return nil, err
+		}
+		binName := r.Artifact.BinName
+		if binName == "" {
+			binName = name
+		}
+		if inner == "" {
+			inner = binName
+		}
+		archive, err := syntheticArtifact(r.Artifact.Format, inner, fmt.Sprintf("#!/bin/sh\necho %q\n", name+" "+version))
+		if err != nil {
+			return nil, err
+		}
+		fs.addDefault(artifactURL, archive)
+
+		// a checksum URL answers with the checksum of whatever is served
+		shaURL, err := renderTemplate(r.Artifact.SHA256Template, tctx)
+		if err != nil {
+			return nil, err
+		}
+		if shaURL != "" && !isHexSHA256(shaURL) {
+			sum := sha256.Sum256(fs.routes[artifactURL].body)
+			fs.addDefault(shaURL, []byte(hex.EncodeToString(sum[:])))
+		}
+	}
+
+	fs.Server = httptest.NewServer(http.HandlerFunc(fs.serve))
+	return fs, nil
+}
+
+// addDefault serves body for u unless a recorded response does
+func (fs *fixtureServer) addDefault(u string, body []byte) {
+	if _, ok := fs.routes[u]; !ok {
+		fs.routes[u] = fixture{body: body}
+	}
+}
+
+func (fs *fixtureServer) serve(w http.ResponseWriter, req *http.Request) {
+	original := req.Header.Get(originalURLHeader)
+	if f, ok := fs.routes[original]; ok {
+		if f.status != 0 {
+			w.WriteHeader(f.status)
+		}
+		_, _ = w.Write(f.body)
+		return
+	}
+	if u, err := url.Parse(original); err == nil && u.Host == "api.github.com" {
+		if m := githubAPI.FindStringSubmatch(u.Path); m != nil {
+			tag := "v" + fs.version
+			var body any
+			switch m[1] {
+			case "releases/latest":
+				body = ghRelease{TagName: tag}
+			case "releases":
+				body = []ghRelease{{TagName: tag}}
+			case "tags":
+				body = []ghTag{{Name: tag}}
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(body)
+			return
+		}
+	}
+	fs.mu.Lock()
+	fs.missed = append(fs.missed, original)
+	fs.mu.Unlock()
+	http.Error(w, "no fixture for "+original, http.StatusNotFound)
+}
+
+// Transport sends every request to the fixture server
+func (fs *fixtureServer) Transport() http.RoundTripper {
+	target, _ := url.Parse(fs.URL)
+	return roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		out := req.Clone(req.Context())
+		out.Header.Set(originalURLHeader, req.URL.String())
+		out.URL.Scheme = target.Scheme
+		out.URL.Host = target.Host
+		out.Host = target.Host
+		return http.DefaultTransport.RoundTrip(out)
+	})
+}
+
+// explain adds the URLs that had no fixture to err
+func (fs *fixtureServer) explain(err error) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if len(fs.missed) == 0 {
+		return err
+	}
+	return fmt.Errorf("%w (no fixture for %s; record one in %s)", err, strings.Join(fs.missed, ", "), RecipeTestFile)
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+// syntheticArtifact builds an artifact of format with an executable script
+// at inner, or the script itself for raw artifacts
+func syntheticArtifact(format, inner, script string) ([]byte, error) {
+	var buf bytes.Buffer
+	inner = path.Clean(filepath.ToSlash(inner))
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "", "raw":
+		return []byte(script), nil
+	case "gz":
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write([]byte(script)); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+	case "tar.gz", "tgz":
+		zw := gzip.NewWriter(&buf)
+		tw := tar.NewWriter(zw)
+		if err := tw.WriteHeader(&tar.Header{Name: inner, Mode: 0o755, Size: int64(len(script)), Typeflag: tar.TypeReg}); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write([]byte(script)); err != nil {
+			return nil, err
+		}
+		if err := tw.Close(); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+	case "zip":
+		zw := zip.NewWriter(&buf)
+		h := &zip.FileHeader{Name: inner, Method: zip.Deflate}
+		h.SetMode(0o755)
+		w, err := zw.CreateHeader(h)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write([]byte(script)); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("cannot synthesize a %q artifact", format)
+	}
+	return buf.Bytes(), nil
+}
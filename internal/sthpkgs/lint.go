@@ -0,0 +1,316 @@
+package sthpkgs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Finding is one lint rule a recipe breaks
+type Finding struct {
+	Rule     string   `json:"rule"`
+	Severity Severity `json:"severity"`
+	Field    string   `json:"field"` // e.g. "artifact.urlTemplate"
+	Line     int      `json:"line,omitempty"`
+	Message  string   `json:"message"`
+}
+
+// LintRule is a recipe quality check. Findings of a rule are silenced by a
+// `# sthpkgs:ignore <id>` comment on or above the reported key or one of
+// its parents, or in the file header; a bare `# sthpkgs:ignore` silences
+// every rule.
+type LintRule struct {
+	ID          string
+	Severity    Severity
+	Description string
+
+	// check reports findings through report; folder is the name of the
+	// directory holding the recipe
+	check func(r Recipe, folder string, report func(field, format string, args ...any))
+}
+
+// ignoreDirective starts comments that silence lint rules
+const ignoreDirective = "sthpkgs:ignore"
+
+var (
+	usrPath  = regexp.MustCompile(`(^|[\s'"=:;(])/usr(/|\s|$)`)
+	tplBlock = regexp.MustCompile(`{{.*?}}`)
+)
+
+// LintRules are the rules `sthpkgs lint` checks
+var LintRules = []LintRule{
+	{
+		ID:          "missing-checksum",
+		Severity:    SeverityWarning,
+		Description: "the artifact download is not verified by a sha256Template or verify action",
+		check: func(r Recipe, _ string, report func(string, string, ...any)) {
+			if r.Artifact.IsEmpty() || strings.TrimSpace(r.Artifact.SHA256Template) != "" {
+				return
+			}
+			for _, a := range r.Actions {
+				if a.Type == "verify" {
+					return
+				}
+			}
+			report("artifact", "downloads are not verified, add a sha256Template")
+		},
+	},
+	{
+		ID:          "static-version",
+		Severity:    SeverityWarning,
+		Description: "a static version has no repo or url to check for updates",
+		check: func(r Recipe, _ string, report func(string, string, ...any)) {
+			vs := r.Artifact.Version
+			if vs.Type == "static" && vs.Repo == "" && vs.URL == "" {
+				report("artifact.version.type", "static version %s has no repo or url to check for updates", vs.Value)
+			}
+		},
+	},
+	{
+		ID:          "shell-system-path",
+		Severity:    SeverityError,
+		Description: "a shell action touches /usr without system: true",
+		check: func(r Recipe, _ string, report func(string, string, ...any)) {
+			for i, a := range r.Actions {
+				if a.Type == "shell" && !a.System && usrPath.MatchString(a.Args["cmd"]) {
+					report(fmt.Sprintf("actions[%d].args.cmd", i), "touches /usr but runs without system: true")
+				}
+			}
+		},
+	},
+	{
+		ID:          "untargeted-linux-url",
+		Severity:    SeverityWarning,
+		Description: "the urlTemplate hard-codes linux but target.os is not set",
+		check: func(r Recipe, _ string, report func(string, string, ...any)) {
+			literal := tplBlock.ReplaceAllString(r.Artifact.URLTemplate, "")
+			if len(r.Target.OS) == 0 && strings.Contains(strings.ToLower(literal), "linux") {
+				report("artifact.urlTemplate", "hard-codes linux, set target.os or use {{.OS}}")
+			}
+		},
+	},
+	{
+		ID:          "unused-template-variable",
+		Severity:    SeverityWarning,
+		Description: "the urlTemplate ignores the version, or the OS or arch although the recipe targets several",
+		check: func(r Recipe, _ string, report func(string, string, ...any)) {
+			if strings.TrimSpace(r.Artifact.URLTemplate) == "" {
+				return
+			}
+			used, err := templateVars(r.Artifact.URLTemplate)
+			if err != nil {
+				return // reported by validate
+			}
+			if !slices.Contains(used, "Version") {
+				report("artifact.urlTemplate", "does not use .Version, every version downloads the same file")
+			}
+			if len(r.Target.OS) != 1 && !slices.Contains(used, "OS") {
+				report("artifact.urlTemplate", "does not use .OS, but target.os allows more than one OS")
+			}
+			if len(r.Target.Arch) != 1 && !slices.Contains(used, "Arch") {
+				report("artifact.urlTemplate", "does not use .Arch, but target.arch allows more than one arch")
+			}
+		},
+	},
+	{
+		ID:          "slug-folder-mismatch",
+		Severity:    SeverityWarning,
+		Description: "the slug differs from the recipe's folder, which names it in the index",
+		check: func(r Recipe, folder string, report func(string, string, ...any)) {
+			if r.Slug != "" && folder != "" && r.Slug != folder {
+				report("slug", "%q differs from the folder %q the index key is built from", r.Slug, folder)
+			}
+		},
+	},
+	{
+		ID:          "insecure-url",
+		Severity:    SeverityError,
+		Description: "a URL is not HTTPS",
+		check: func(r Recipe, _ string, report func(string, string, ...any)) {
+			urls := []struct{ field, value string }{
+				{"artifact.urlTemplate", r.Artifact.URLTemplate},
+				{"artifact.sha256Template", r.Artifact.SHA256Template},
+				{"artifact.version.url", r.Artifact.Version.URL},
+			}
+			for i, a := range r.Actions {
+				if a.Type == "download" {
+					urls = append(urls, struct{ field, value string }{fmt.Sprintf("actions[%d].args.url", i), a.Args["url"]})
+				}
+			}
+			for _, u := range urls {
+				scheme, _, ok := strings.Cut(strings.TrimSpace(u.value), "://")
+				if ok && !strings.EqualFold(scheme, "https") && !strings.Contains(scheme, "{{") {
+					report(u.field, "uses %s, use https", scheme)
+				}
+			}
+		},
+	},
+}
+
+// LintRecipe checks r against LintRules. folder is the name of the directory
+// the recipe is in, or "" if unknown.
+func LintRecipe(r Recipe, folder string) []Finding {
+	var findings []Finding
+	for _, rule := range LintRules {
+		rule.check(r, folder, func(field, format string, args ...any) {
+			findings = append(findings, Finding{
+				Rule:     rule.ID,
+				Severity: rule.Severity,
+				Field:    field,
+				Message:  fmt.Sprintf(format, args...),
+			})
+		})
+	}
+	return findings
+}
+
+// LintRecipeFile lints a recipe file, adds the line of each finding and
+// leaves out the findings silenced by ignore comments
+func LintRecipeFile(path string) ([]Finding, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	var raw Recipe
+	if err := root.Decode(&raw); err != nil {
+		return nil, err
+	}
+	r, err := loadRecipe(path)
+	if err != nil {
+		return nil, err
+	}
+	// the slug rule needs the slug as written, not defaulted to the folder
+	r.Slug = raw.Slug
+
+	idx := indexNodes(&root)
+	var kept []Finding
+	for _, f := range LintRecipe(r, filepath.Base(filepath.Dir(path))) {
+		if idx.ignored(f.Field, f.Rule) {
+			continue
+		}
+		f.Line = idx.line(f.Field)
+		kept = append(kept, f)
+	}
+	sort.SliceStable(kept, func(i, j int) bool { return kept[i].Line < kept[j].Line })
+	return kept, nil
+}
+
+// nodeIndex maps field paths such as "actions[0].args.cmd" to their lines
+// and the ignore comments on them
+type nodeIndex struct {
+	lines   map[string]int
+	ignores map[string][]string // rule IDs, "*" for all
+}
+
+func indexNodes(root *yaml.Node) *nodeIndex {
+	idx := &nodeIndex{lines: map[string]int{}, ignores: map[string][]string{}}
+	// yaml.v3 attaches a comment above the first key to that key, so it
+	// counts as the file header too
+	header := []string{root.HeadComment}
+	if len(root.Content) > 0 {
+		doc := root.Content[0]
+		header = append(header, doc.HeadComment)
+		if doc.Kind == yaml.MappingNode && len(doc.Content) > 0 {
+			header = append(header, doc.Content[0].HeadComment)
+		}
+	}
+	idx.ignores[""] = ignoredRules(header...)
+
+	var walk func(n *yaml.Node, path string)
+	walk = func(n *yaml.Node, path string) {
+		switch n.Kind {
+		case yaml.DocumentNode:
+			for _, c := range n.Content {
+				walk(c, path)
+			}
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(n.Content); i += 2 {
+				key, value := n.Content[i], n.Content[i+1]
+				p := key.Value
+				if path != "" {
+					p = path + "." + key.Value
+				}
+				idx.lines[p] = key.Line
+				idx.ignores[p] = ignoredRules(key.HeadComment, key.LineComment, value.LineComment)
+				walk(value, p)
+			}
+		case yaml.SequenceNode:
+			for i, item := range n.Content {
+				p := fmt.Sprintf("%s[%d]", path, i)
+				idx.lines[p] = item.Line
+				idx.ignores[p] = ignoredRules(item.HeadComment, item.LineComment)
+				walk(item, p)
+			}
+		}
+	}
+	walk(root, "")
+	return idx
+}
+
+// line returns the line of field, or of its closest parent in the file
+func (idx *nodeIndex) line(field string) int {
+	for p := field; p != ""; p = parentPath(p) {
+		if l, ok := idx.lines[p]; ok {
+			return l
+		}
+	}
+	return 1
+}
+
+// ignored reports whether rule is silenced on field or one of its parents
+func (idx *nodeIndex) ignored(field, rule string) bool {
+	for p := field; ; p = parentPath(p) {
+		for _, id := range idx.ignores[p] {
+			if id == "*" || id == rule {
+				return true
+			}
+		}
+		if p == "" {
+			return false
+		}
+	}
+}
+
+func parentPath(p string) string {
+	if i := strings.LastIndexAny(p, ".["); i >= 0 {
+		return p[:i]
+	}
+	return ""
+}
+
+// ignoredRules parses `# sthpkgs:ignore rule-a, rule-b` comments
+func ignoredRules(comments ...string) []string {
+	var rules []string
+	for _, c := range comments {
+		for _, line := range strings.Split(c, "\n") {
+			line = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "#"))
+			rest, ok := strings.CutPrefix(line, ignoreDirective)
+			if !ok {
+				continue
+			}
+			ids := strings.FieldsFunc(rest, func(r rune) bool { return r == ',' || r == ' ' })
+			if len(ids) == 0 {
+				ids = []string{"*"}
+			}
+			rules = append(rules, ids...)
+		}
+	}
+	return rules
+}
@@ -0,0 +1,173 @@
+package sthpkgs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// lintBase is a recipe no rule reports
+func lintBase() Recipe {
+	return Recipe{
+		Name:   "age",
+		Slug:   "age",
+		Target: Target{OS: []string{"linux"}, Arch: []string{"amd64"}},
+		Artifact: Artifact{
+			Version:        VersionSource{Type: "githubRelease", Repo: "FiloSottile/age"},
+			URLTemplate:    "https://example.com/age-{{.Version}}-linux-amd64.tar.gz",
+			SHA256Template: "https://example.com/age-{{.Version}}.sha256",
+		},
+	}
+}
+
+func TestLintRules(t *testing.T) {
+	tests := []struct {
+		name   string
+		edit   func(r *Recipe)
+		folder string
+		want   []string // "<rule> <field>"
+	}{
+		{"clean", func(r *Recipe) {}, "age", nil},
+		{"missing checksum", func(r *Recipe) { r.Artifact.SHA256Template = "" }, "age", []string{"missing-checksum artifact"}},
+		{"verify action counts as checksum", func(r *Recipe) {
+			r.Artifact.SHA256Template = ""
+			r.Actions = []InstallAction{{Type: "verify"}}
+		}, "age", nil},
+		{"static version", func(r *Recipe) {
+			r.Artifact.Version = VersionSource{Type: "static", Value: "1.2.0"}
+		}, "age", []string{"static-version artifact.version.type"}},
+		{"static version with repo", func(r *Recipe) {
+			r.Artifact.Version = VersionSource{Type: "static", Value: "1.2.0", Repo: "FiloSottile/age"}
+		}, "age", nil},
+		{"shell touching /usr", func(r *Recipe) {
+			r.Actions = []InstallAction{{Type: "move"}, {Type: "shell", Args: map[string]string{"cmd": "cp age /usr/local/bin"}}}
+		}, "age", []string{"shell-system-path actions[1].args.cmd"}},
+		{"system shell touching /usr", func(r *Recipe) {
+			r.Actions = []InstallAction{{Type: "shell", System: true, Args: map[string]string{"cmd": "cp age /usr/local/bin"}}}
+		}, "age", nil},
+		{"shell touching a /usr lookalike", func(r *Recipe) {
+			r.Actions = []InstallAction{{Type: "shell", Args: map[string]string{"cmd": "cp age ~/usrbin"}}}
+		}, "age", nil},
+		{"untargeted linux url", func(r *Recipe) {
+			r.Target.OS = nil
+			r.Artifact.URLTemplate = "https://example.com/age-{{.Version}}-{{.OS}}-linux-amd64.tar.gz"
+		}, "age", []string{"untargeted-linux-url artifact.urlTemplate"}},
+		{"linux only inside a template", func(r *Recipe) {
+			r.Target.OS = nil
+			r.Artifact.URLTemplate = `https://example.com/age-{{.Version}}-{{if eq .OS "linux"}}l{{end}}-{{.OS}}-amd64.tar.gz`
+		}, "age", nil},
+		{"unused template variables", func(r *Recipe) {
+			r.Target = Target{}
+			r.Artifact.URLTemplate = "https://example.com/age.tar.gz"
+		}, "age", []string{
+			"unused-template-variable artifact.urlTemplate",
+			"unused-template-variable artifact.urlTemplate",
+			"unused-template-variable artifact.urlTemplate",
+		}},
+		{"slug folder mismatch", func(r *Recipe) {}, "rage", []string{"slug-folder-mismatch slug"}},
+		{"unknown folder", func(r *Recipe) {}, "", nil},
+		{"insecure urls", func(r *Recipe) {
+			r.Artifact.URLTemplate = "http://example.com/age-{{.Version}}.tar.gz"
+			r.Actions = []InstallAction{{Type: "download", Args: map[string]string{"url": "ftp://example.com/x"}}}
+		}, "age", []string{"insecure-url artifact.urlTemplate", "insecure-url actions[0].args.url"}},
+		{"templated scheme", func(r *Recipe) {
+			r.Artifact.URLTemplate = "{{.Scheme}}://example.com/age-{{.Version}}.tar.gz"
+		}, "age", nil},
+	}
+	for _, tt := range tests {
+		r := lintBase()
+		tt.edit(&r)
+		var got []string
+		for _, f := range LintRecipe(r, tt.folder) {
+			got = append(got, f.Rule+" "+f.Field)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// lintFile is a recipe in folder "rage" with a slug mismatch, an
+// unverified download and a shell action touching /usr
+const lintFile = `name: age
+slug: age
+target:
+  os: [linux]
+  arch: [amd64]
+artifact:
+  version:
+    type: githubRelease
+    repo: FiloSottile/age
+  urlTemplate: "https://example.com/age-{{.Version}}-linux-amd64.tar.gz"
+actions:
+  - type: shell
+    args:
+      cmd: cp age /usr/local/bin
+`
+
+func TestLintRecipeFile(t *testing.T) {
+	all := []string{"slug-folder-mismatch:2", "missing-checksum:6", "shell-system-path:14"}
+	tests := []struct {
+		name string
+		file string
+		want []string // "<rule>:<line>"
+	}{
+		{"no ignores", lintFile, all},
+		{"header right above the first key", "# sthpkgs:ignore slug-folder-mismatch\n" + lintFile, []string{"missing-checksum:7", "shell-system-path:15"}},
+		{"header apart from the first key", "# sthpkgs:ignore missing-checksum\n\n" + lintFile, []string{"slug-folder-mismatch:4", "shell-system-path:16"}},
+		{"bare header ignores every rule", "# sthpkgs:ignore\n" + lintFile, nil},
+		{"line comment on the key", replace(lintFile, "slug: age\n", "slug: age # sthpkgs:ignore slug-folder-mismatch\n"), all[1:]},
+		{"comment above the key", replace(lintFile, "artifact:\n", "# sthpkgs:ignore missing-checksum\nartifact:\n"), []string{"slug-folder-mismatch:2", "shell-system-path:15"}},
+		{"parent silences children", replace(lintFile, "actions:\n", "actions: # sthpkgs:ignore shell-system-path\n"), all[:2]},
+		{"list entry", replace(lintFile, "  - type: shell\n", "  # sthpkgs:ignore shell-system-path\n  - type: shell\n"), all[:2]},
+		{"several rules in one comment", "# sthpkgs:ignore slug-folder-mismatch, missing-checksum\n" + lintFile, []string{"shell-system-path:15"}},
+		{"other rule", replace(lintFile, "actions:\n", "actions: # sthpkgs:ignore insecure-url\n"), all},
+		{"sibling is not silenced", replace(lintFile, "  version:\n", "  # sthpkgs:ignore missing-checksum\n  version:\n"), []string{"slug-folder-mismatch:2", "missing-checksum:6", "shell-system-path:15"}},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "rage", "recipe.yml")
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(tt.file), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		findings, err := LintRecipeFile(path)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		var got []string
+		for _, f := range findings {
+			got = append(got, fmt.Sprintf("%s:%d", f.Rule, f.Line))
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParentPath(t *testing.T) {
+	tests := map[string]string{
+		"actions[1].args.cmd": "actions[1].args",
+		"actions[1].args":     "actions[1]",
+		"actions[1]":          "actions",
+		"actions":             "",
+		"":                    "",
+	}
+	for in, want := range tests {
+		if got := parentPath(in); got != want {
+			t.Errorf("parentPath(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func replace(s, old, new string) string {
+	if !strings.Contains(s, old) {
+		panic("replace: " + old + " not found")
+	}
+	return strings.Replace(s, old, new, 1)
+}
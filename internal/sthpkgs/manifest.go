@@ -0,0 +1,164 @@
+package sthpkgs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aottr/sth/internal/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// Manifest records an installed artifact so that sth can tell what it
+// installed, in which version, and where
+type Manifest struct {
+	Name        string    `yaml:"name" json:"name"`
+	Slug        string    `yaml:"slug,omitempty" json:"slug,omitempty"`
+	Version     string    `yaml:"version" json:"version"`
+	Scope       string    `yaml:"scope,omitempty" json:"scope,omitempty"`
+	InstallDir  string    `yaml:"installDir,omitempty" json:"installDir,omitempty"`
+	BinaryPath  string    `yaml:"binaryPath,omitempty" json:"binaryPath,omitempty"`
+	Links       []string  `yaml:"links,omitempty" json:"links,omitempty"`
+	InstalledAt time.Time `yaml:"installedAt" json:"installedAt"`
+
+	path string
+}
+
+// DefaultPaths returns the install paths for a scope without overrides
+func DefaultPaths(scope InstallScope) Paths {
+	return resolvePaths(scope, Paths{})
+}
+
+// ManifestKey is the file stem of a recipe's manifest
+func ManifestKey(rr ResolveResult) string {
+	return strings.TrimSpace(utils.FirstNonEmpty(rr.Recipe.Slug, rr.Resolved.Name, rr.Recipe.Name))
+}
+
+func manifestPath(paths Paths, key string) string {
+	return filepath.Join(paths.Manifests, key+".yml")
+}
+
+// writeManifest records rr after a successful install
+func writeManifest(rr ResolveResult) error {
+	key := ManifestKey(rr)
+	if key == "" {
+		return nil
+	}
+	m := Manifest{
+		Name:        rr.Resolved.Name,
+		Slug:        rr.Recipe.Slug,
+		Version:     rr.Resolved.Version,
+		Scope:       string(rr.Recipe.Scope),
+		InstallDir:  rr.Resolved.InstallDir,
+		BinaryPath:  rr.Resolved.BinaryPath,
+		InstalledAt: time.Now().UTC().Truncate(time.Second),
+	}
+	for _, a := range rr.Actions {
+		if a.Type == "symlink" && a.Args["dest"] != "" {
+			m.Links = append(m.Links, a.Args["dest"])
+		}
+	}
+	if err := os.MkdirAll(rr.Paths.Manifests, 0o755); err != nil {
+		return fmt.Errorf("manifests dir: %w", err)
+	}
+	out, err := yaml.Marshal(&m)
+	if err != nil {
+		return fmt.Errorf("marshal manifest: %w", err)
+	}
+	return os.WriteFile(manifestPath(rr.Paths, key), out, 0o644)
+}
+
+// ReadManifest returns the manifest for key, or nil if there is none
+func ReadManifest(paths Paths, key string) (*Manifest, error) {
+	p := manifestPath(paths, key)
+	b, err := os.ReadFile(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var m Manifest
+	if err := yaml.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", p, err)
+	}
+	m.path = p
+	return &m, nil
+}
+
+// ListManifests returns all manifests under paths, ordered by name
+func ListManifests(paths Paths) ([]Manifest, error) {
+	entries, err := os.ReadDir(paths.Manifests)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var out []Manifest
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".yml" {
+			continue
+		}
+		m, err := ReadManifest(paths, strings.TrimSuffix(e.Name(), ".yml"))
+		if err != nil {
+			return nil, err
+		}
+		if m != nil {
+			out = append(out, *m)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key() < out[j].Key() })
+	return out, nil
+}
+
+// Key is the name the manifest is stored under
+func (m Manifest) Key() string {
+	if m.path != "" {
+		return strings.TrimSuffix(filepath.Base(m.path), ".yml")
+	}
+	return utils.FirstNonEmpty(m.Slug, m.Name)
+}
+
+// Linked reports whether every recorded symlink still points at the binary
+func (m Manifest) Linked() bool {
+	if m.BinaryPath == "" {
+		return true
+	}
+	if st, err := os.Stat(m.BinaryPath); err != nil || (st.Mode()&0o111) == 0 {
+		return false
+	}
+	for _, l := range m.Links {
+		if !linksTo(l, m.BinaryPath) {
+			return false
+		}
+	}
+	return true
+}
+
+// Uninstall removes the symlinks, install directory and manifest recorded by
+// m. The install directory is only removed when it lies below paths.PkgsDir.
+func (m Manifest) Uninstall(paths Paths) error {
+	for _, l := range m.Links {
+		if !linksTo(l, m.BinaryPath) {
+			continue
+		}
+		if err := os.Remove(l); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("remove link %s: %w", l, err)
+		}
+	}
+	if rel, err := filepath.Rel(paths.PkgsDir, m.InstallDir); err == nil && m.InstallDir != "" && rel != "." && !strings.HasPrefix(rel, "..") {
+		if err := os.RemoveAll(m.InstallDir); err != nil {
+			return fmt.Errorf("remove %s: %w", m.InstallDir, err)
+		}
+	}
+	if m.path != "" {
+		if err := os.Remove(m.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
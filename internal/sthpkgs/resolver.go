@@ -35,6 +35,9 @@ func ResolveRecipe(ctx context.Context, r Recipe) (ResolveResult, error) {
 		Arch:   r.Target.Arch,
 		Distro: utils.FirstNonEmpty(r.Target.Distro, pi.Distro),
 		Family: utils.FirstNonEmpty(r.Target.Family, pi.Family),
+
+		Version:  r.Target.Version,
+		Codename: r.Target.Codename,
 	}
 
 	if err := ensureTargetSupported(target, pi); err != nil {
@@ -60,14 +63,7 @@ func ResolveRecipe(ctx context.Context, r Recipe) (ResolveResult, error) {
 		name = r.Name
 	}
 
-	tctx := map[string]string{
-		"Name":    name,
-		"Version": version,
-		"OS":      pi.OS,
-		"Arch":    pi.Arch,
-		"Distro":  pi.Distro,
-		"Family":  pi.Family,
-	}
+	tctx := templateContext(name, version, pi)
 	url, err := renderTemplate(r.Artifact.URLTemplate, tctx)
 	if err != nil {
 		fmt.Println("Could not render template")
@@ -151,6 +147,21 @@ func ResolveRecipe(ctx context.Context, r Recipe) (ResolveResult, error) {
 	}, nil
 }
 
+// templateContext holds the variables of the artifact templates, see artifactVars
+func templateContext(name, version string, pi platform.Info) map[string]string {
+	return map[string]string{
+		"Name":    name,
+		"Version": version,
+		"OS":      pi.OS,
+		"Arch":    pi.Arch,
+		"Distro":  pi.Distro,
+		"Family":  pi.Family,
+
+		"DistroVersion": pi.Version,
+		"Codename":      pi.Codename,
+	}
+}
+
 func renderActionArgs(a InstallAction, tctx map[string]string) (map[string]string, error) {
 	if len(a.Args) == 0 {
 		return nil, nil
@@ -166,9 +177,9 @@ func renderActionArgs(a InstallAction, tctx map[string]string) (map[string]strin
 	return out, nil
 }
 
-// ensureTargetSupported validates detected OS/Arch against allowlists if provided
+// ensureTargetSupported validates detected OS/Arch/Version/Codename against allowlists if provided
 func ensureTargetSupported(t Target, pi platform.Info) error {
-	if len(t.OS) == 0 && len(t.Arch) == 0 {
+	if len(t.OS) == 0 && len(t.Arch) == 0 && len(t.Version) == 0 && len(t.Codename) == 0 {
 		return nil
 	}
 	if len(t.OS) > 0 {
@@ -181,6 +192,16 @@ func ensureTargetSupported(t Target, pi platform.Info) error {
 			return fmt.Errorf("unsupported Arch %q (allowed: %v)", pi.Arch, t.Arch)
 		}
 	}
+	if len(t.Version) > 0 {
+		if !containsFold(t.Version, pi.Version) {
+			return fmt.Errorf("unsupported release %q (allowed: %v)", pi.Version, t.Version)
+		}
+	}
+	if len(t.Codename) > 0 {
+		if !containsFold(t.Codename, pi.Codename) {
+			return fmt.Errorf("unsupported codename %q (allowed: %v)", pi.Codename, t.Codename)
+		}
+	}
 	return nil
 }
 
@@ -353,7 +374,7 @@ func httpRegexVersion(ctx context.Context, vs VersionSource) (string, error) {
 		return "", fmt.Errorf("httpRegexVersion: request: %w", err)
 	}
 	req.Header.Set("User-Agent", "sth/1.0")
-	client := &http.Client{Timeout: 10 * time.Second}
+	client := httpClient(ctx, 10*time.Second)
 
 	resp, err := client.Do(req)
 	if err != nil || resp == nil {
@@ -408,7 +429,7 @@ func githubLatestTag(ctx context.Context, vs VersionSource) (string, error) {
 		}
 		return "", fmt.Errorf("githubTag: repo missing")
 	}
-	client := githubClient()
+	client := githubClient(ctx)
 	// Fetch first 100 tags and pick the highest semver
 	url := fmt.Sprintf("https://api.github.com/repos/%s/tags?per_page=100", vs.Repo)
 	req, err := githubReq(ctx, url)
@@ -474,7 +495,7 @@ func githubLatestRelease(ctx context.Context, vs VersionSource) (string, error)
 		}
 		return "", fmt.Errorf("githubRelease: repo missing")
 	}
-	client := githubClient()
+	client := githubClient(ctx)
 
 	if !vs.Prerelease && strings.TrimSpace(vs.Constraint) == "" {
 		url := fmt.Sprintf("https://api.github.com/repos/%s/releases/latest", vs.Repo)
@@ -561,7 +582,20 @@ func renderTemplate(tpl string, ctx map[string]string) (string, error) {
 		return "", nil
 	}
 
-	funcs := template.FuncMap{
+	t, err := template.New("tpl").Funcs(templateFuncs(ctx)).Option("missingkey=default").Parse(tpl)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, ctx); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// templateFuncs are the functions recipe templates may call
+func templateFuncs(ctx map[string]string) template.FuncMap {
+	return template.FuncMap{
 		"lower":   strings.ToLower,
 		"upper":   strings.ToUpper,
 		"replace": strings.ReplaceAll,
@@ -572,16 +606,6 @@ func renderTemplate(tpl string, ctx map[string]string) (string, error) {
 			return ""
 		},
 	}
-
-	t, err := template.New("tpl").Funcs(funcs).Option("missingkey=default").Parse(tpl)
-	if err != nil {
-		return "", err
-	}
-	var buf bytes.Buffer
-	if err := t.Execute(&buf, ctx); err != nil {
-		return "", err
-	}
-	return strings.TrimSpace(buf.String()), nil
 }
 
 func resolveSHA256(ctx context.Context, shaTpl string, tctx map[string]string) (string, error) {
@@ -603,7 +627,7 @@ func resolveSHA256(ctx context.Context, shaTpl string, tctx map[string]string) (
 		return "", fmt.Errorf("resolveSHA256: request: %w", err)
 	}
 	req.Header.Set("User-Agent", "sth/1.0")
-	client := &http.Client{Timeout: 10 * time.Second}
+	client := httpClient(ctx, 10*time.Second)
 
 	resp, err := client.Do(req)
 	if err != nil {
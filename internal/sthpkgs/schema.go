@@ -0,0 +1,27 @@
+package sthpkgs
+
+import (
+	"reflect"
+
+	"github.com/aottr/sth/internal/schema"
+	"github.com/aottr/sth/internal/utils"
+)
+
+// RecipeSchemaID is where the recipe.yml schema is published
+const RecipeSchemaID = "https://raw.githubusercontent.com/aottr/sth/main/schemas/recipe.schema.json"
+
+// RecipeSchema returns the JSON Schema of recipe.yml
+func RecipeSchema() ([]byte, error) {
+	enum := func(values ...string) schema.Schema {
+		return schema.Schema{"type": "string", "enum": values}
+	}
+	g := &schema.Generator{
+		Fields: map[reflect.Type]map[string]schema.Schema{
+			reflect.TypeOf(Recipe{}):        {"scope": enum(string(InstallScopeUser), string(InstallScopeSystem))},
+			reflect.TypeOf(Artifact{}):      {"format": enum("raw", "gz", "tar.gz", "tgz", "zip")},
+			reflect.TypeOf(VersionSource{}): {"type": enum("static", "githubRelease", "githubTag", "regex")},
+			reflect.TypeOf(InstallAction{}): {"type": enum(utils.SortedKeys(actionArgs)...)},
+		},
+	}
+	return g.Generate(Recipe{}, RecipeSchemaID, "sth recipe.yml")
+}
@@ -2,19 +2,25 @@ package sthpkgs
 
 import (
 	"fmt"
-	"net/http"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
+	"time"
 
+	"github.com/aottr/sth/internal/cassette"
+	"github.com/aottr/sth/internal/platform"
+	"github.com/aottr/sth/internal/utils"
 	"gopkg.in/yaml.v3"
 )
 
 const RecipesBase = "https://raw.githubusercontent.com/aottr/sthpkgs/refs/heads/main/"
 
+// fetchTimeout bounds fetching a recipe or the index
+const fetchTimeout = 30 * time.Second
+
 func FetchPackageRecipe(name string) (*Recipe, error) {
-	resp, err := http.Get(RecipesBase + name)
+	resp, err := cassette.Client(fetchTimeout).Get(RecipesBase + name)
 	if err != nil {
 		return nil, fmt.Errorf("failed to GET recipe: %w", err)
 	}
@@ -31,7 +37,7 @@ func FetchPackageRecipe(name string) (*Recipe, error) {
 }
 
 func FetchRecipeIndex() (*RecipeIndex, error) {
-	resp, err := http.Get(RecipesBase + "index.yaml")
+	resp, err := cassette.Client(fetchTimeout).Get(RecipesBase + "index.yaml")
 	if err != nil {
 		return nil, fmt.Errorf("failed to GET index: %w", err)
 	}
@@ -47,6 +53,33 @@ func FetchRecipeIndex() (*RecipeIndex, error) {
 	return &index, nil
 }
 
+// FindIndexEntry returns the entry for name that supports pi. name may be an
+// index key ("age@linux-amd64"), a folder name or a slug.
+func FindIndexEntry(idx *RecipeIndex, name string, pi platform.Info) (*RecipeIndexEntry, bool) {
+	name = strings.TrimSpace(name)
+	keys := make([]string, 0, len(idx.Recipes))
+	for k := range idx.Recipes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		e := idx.Recipes[k]
+		base, _, _ := strings.Cut(k, "@")
+		if k != name && base != name && e.Slug != name {
+			continue
+		}
+		if len(e.OS) > 0 && !containsFold(e.OS, pi.OS) {
+			continue
+		}
+		if len(e.Arch) > 0 && !containsFold(e.Arch, pi.Arch) {
+			continue
+		}
+		e.Key = k
+		return &e, true
+	}
+	return nil, false
+}
+
 func ListRecipes() error {
 
 	idx, err := FetchRecipeIndex()
@@ -78,7 +111,7 @@ func ListRecipes() error {
 
 // GenerateIndex scans recipesDir for */recipe.yaml and writes index.yaml to outPath.
 func GenerateIndex(recipesDir, outPath string) error {
-	files, err := scanRecipes(recipesDir)
+	files, err := ScanRecipes(recipesDir)
 	if err != nil {
 		return fmt.Errorf("scan recipes: %w", err)
 	}
@@ -126,7 +159,7 @@ func GenerateIndex(recipesDir, outPath string) error {
 	return nil
 }
 
-func scanRecipes(recipesDir string) ([]string, error) {
+func ScanRecipes(recipesDir string) ([]string, error) {
 	var files []string
 	err := filepath.WalkDir(recipesDir, func(p string, d os.DirEntry, err error) error {
 		if err != nil {
@@ -150,7 +183,7 @@ func loadRecipe(path string) (Recipe, error) {
 		return Recipe{}, err
 	}
 	var r Recipe
-	if err := yaml.Unmarshal(b, &r); err != nil {
+	if err := utils.DecodeStrict(b, &r); err != nil {
 		return Recipe{}, err
 	}
 
@@ -167,6 +200,7 @@ func loadRecipe(path string) (Recipe, error) {
 	// Normalize OS/Arch slices (lowercase)
 	r.Target.OS = cloneAndNormalizeList(r.Target.OS)
 	r.Target.Arch = cloneAndNormalizeList(r.Target.Arch)
+	r.Target.Codename = cloneAndNormalizeList(r.Target.Codename)
 
 	return r, nil
 }
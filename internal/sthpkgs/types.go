@@ -1,6 +1,9 @@
 package sthpkgs
 
-import "strings"
+import (
+	"sort"
+	"strings"
+)
 
 type InstallScope string
 
@@ -28,6 +31,10 @@ type Target struct {
 	Distro string   `yaml:"distro,omitempty" json:"distro,omitempty"` // e.g., "ubuntu","debian"
 	Family string   `yaml:"family,omitempty" json:"family,omitempty"` // e.g., "debian","rhel"
 	Arch   []string `yaml:"arch,omitempty" json:"arch,omitempty"`     // ["amd64","arm64"]
+
+	// os-release VERSION_ID / VERSION_CODENAME allowlists
+	Version  []string `yaml:"version,omitempty" json:"version,omitempty"`   // ["22.04","24.04"]
+	Codename []string `yaml:"codename,omitempty" json:"codename,omitempty"` // ["jammy","noble"]
 }
 
 type VersionSource struct {
@@ -54,7 +61,7 @@ type VersionSource struct {
 // Artifacts are downloadable items using templates... resolved after version has been discovered
 // Template fields are Go text/template using a context that includes:
 //
-//	.Name, .Version, .OS, .Arch, .Distro, .Family etc
+//	.Name, .Version, .OS, .Arch, .Distro, .Family, .DistroVersion, .Codename etc
 type Artifact struct {
 	// Name defaults to recipe Name if empty
 	Name string `yaml:"name,omitempty" json:"name,omitempty"`
@@ -130,6 +137,24 @@ type InstallAction struct {
 	System bool              `yaml:"system,omitempty" json:"system,omitempty"`
 }
 
+// String renders the action with its args in a stable order, e.g.
+// "symlink dest=/home/u/.local/sth/bin/age src=/home/u/.local/sth/pkgs/age-1.2.0/age/age"
+func (a InstallAction) String() string {
+	keys := make([]string, 0, len(a.Args))
+	for k := range a.Args {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := []string{a.Type}
+	for _, k := range keys {
+		parts = append(parts, k+"="+a.Args[k])
+	}
+	if a.System {
+		parts = append(parts, "(system)")
+	}
+	return strings.Join(parts, " ")
+}
+
 // ArtifactResolved is filled at runtime after version discovery and template rendering
 type ArtifactResolved struct {
 	Name      string `json:"name"`
@@ -0,0 +1,247 @@
+package sthpkgs
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/aottr/sth/internal/utils"
+)
+
+// artifactVars are the template variables of the artifact templates
+var artifactVars = []string{"Name", "Version", "OS", "Arch", "Distro", "Family", "DistroVersion", "Codename"}
+
+// actionVars are the template variables of action args, which are rendered
+// after the artifact
+var actionVars = append(slices.Clone(artifactVars), "URL", "CacheFile", "InstallDir", "BinDir")
+
+// actionArgs are the args each action type needs, and the optional ones
+var actionArgs = map[string]struct{ required, optional []string }{
+	"download": {required: []string{"url", "dest"}},
+	"verify":   {required: []string{"file", "sha256"}},
+	"mkdir":    {required: []string{"path"}, optional: []string{"mode"}},
+	"move":     {required: []string{"src", "dest"}},
+	"extract":  {required: []string{"src", "dest"}},
+	"chmod":    {required: []string{"path", "mode"}},
+	"symlink":  {required: []string{"src", "dest"}},
+	"shell":    {required: []string{"cmd"}},
+}
+
+// ValidateRecipeFile decodes a recipe strictly and validates it
+func ValidateRecipeFile(path string) []error {
+	if _, err := os.Stat(path); err != nil {
+		return []error{err}
+	}
+	r, err := loadRecipe(path)
+	if err != nil {
+		return []error{err}
+	}
+	return ValidateRecipe(r)
+}
+
+// ValidateRecipe checks what decoding cannot: known version and action
+// types, the args actions need, artifact options that fit the format and
+// templates that only use defined variables
+func ValidateRecipe(r Recipe) []error {
+	var errs []error
+	fail := func(field, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+	}
+
+	switch r.Scope {
+	case "", InstallScopeUser, InstallScopeSystem:
+	default:
+		fail("scope", "must be %q or %q, not %q", InstallScopeUser, InstallScopeSystem, r.Scope)
+	}
+
+	a := r.Artifact
+	if a.IsEmpty() && len(r.Actions) == 0 && len(r.Steps) == 0 {
+		fail("artifact", "recipe has neither an artifact nor actions")
+	}
+	if !a.IsEmpty() {
+		if strings.TrimSpace(a.URLTemplate) == "" {
+			fail("artifact.urlTemplate", "is required")
+		}
+		format := strings.ToLower(strings.TrimSpace(a.Format))
+		switch format {
+		case "", "raw", "tar.gz", "tgz", "zip":
+		case "gz":
+			fail("artifact.format", "gz is not supported by the executor yet")
+		default:
+			fail("artifact.format", "unknown format %q, use raw, gz, tar.gz or zip", a.Format)
+		}
+		if (format == "" || format == "raw") && strings.TrimSpace(a.InnerPath) != "" {
+			fail("artifact.innerPath", "is not used with format raw")
+		}
+		if a.Mode != "" {
+			if _, err := strconv.ParseUint(a.Mode, 8, 32); err != nil {
+				fail("artifact.mode", "%q is not an octal file mode", a.Mode)
+			}
+		}
+		for _, err := range validateVersion(a.Version) {
+			fail("artifact.version", "%v", err)
+		}
+		for _, tpl := range []struct{ field, value string }{
+			{"artifact.urlTemplate", a.URLTemplate},
+			{"artifact.sha256Template", a.SHA256Template},
+			{"artifact.innerPath", a.InnerPath},
+		} {
+			if err := checkTemplate(tpl.value, artifactVars); err != nil {
+				fail(tpl.field, "%v", err)
+			}
+		}
+	}
+
+	for i, act := range r.Actions {
+		field := fmt.Sprintf("actions[%d]", i)
+		spec, ok := actionArgs[act.Type]
+		if !ok {
+			fail(field+".type", "unknown action %q, use one of %s", act.Type, strings.Join(utils.SortedKeys(actionArgs), ", "))
+			continue
+		}
+		for _, arg := range spec.required {
+			if strings.TrimSpace(act.Args[arg]) == "" {
+				fail(field+".args", "%s needs %s", act.Type, arg)
+			}
+		}
+		for _, arg := range utils.SortedKeys(act.Args) {
+			if !slices.Contains(spec.required, arg) && !slices.Contains(spec.optional, arg) {
+				fail(field+".args."+arg, "is not used by %s", act.Type)
+			}
+			value := act.Args[arg]
+			if a.IsEmpty() {
+				// recipes without an artifact run their actions as written
+				if strings.Contains(value, "{{") {
+					fail(field+".args."+arg, "templates are only rendered for recipes with an artifact")
+				}
+				continue
+			}
+			if err := checkTemplate(value, actionVars); err != nil {
+				fail(field+".args."+arg, "%v", err)
+			}
+		}
+	}
+	return errs
+}
+
+func validateVersion(vs VersionSource) []error {
+	var errs []error
+	switch vs.Type {
+	case "static":
+		if vs.Value == "" && vs.Fallback == "" {
+			errs = append(errs, fmt.Errorf("static needs a value"))
+		}
+	case "githubRelease", "githubTag":
+		if owner, name, ok := strings.Cut(vs.Repo, "/"); !ok || owner == "" || name == "" {
+			errs = append(errs, fmt.Errorf("%s needs repo as owner/name, not %q", vs.Type, vs.Repo))
+		}
+	case "regex":
+		if vs.URL == "" || vs.Pattern == "" {
+			errs = append(errs, fmt.Errorf("regex needs url and pattern"))
+		} else if _, err := regexp.Compile(vs.Pattern); err != nil {
+			errs = append(errs, fmt.Errorf("invalid pattern: %w", err))
+		}
+	case "httpJson":
+		errs = append(errs, fmt.Errorf("httpJson is not implemented yet"))
+	case "":
+		if vs.Fallback == "" {
+			errs = append(errs, fmt.Errorf("type is required"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown type %q, use static, githubRelease, githubTag or regex", vs.Type))
+	}
+	return errs
+}
+
+// checkTemplate parses tpl like renderTemplate and fails on variables that
+// are not in vars, e.g. {{.Verison}} or {{get "Verison"}}
+func checkTemplate(tpl string, vars []string) error {
+	used, err := templateVars(tpl)
+	if err != nil {
+		return err
+	}
+	var undefined []string
+	for _, name := range used {
+		if !slices.Contains(vars, name) {
+			undefined = append(undefined, name)
+		}
+	}
+	if len(undefined) > 0 {
+		return fmt.Errorf("undefined variables %s, available are %s", strings.Join(undefined, ", "), strings.Join(vars, ", "))
+	}
+	return nil
+}
+
+// templateVars returns the variables tpl uses, in order of appearance
+func templateVars(tpl string) ([]string, error) {
+	if strings.TrimSpace(tpl) == "" {
+		return nil, nil
+	}
+	t, err := template.New("tpl").Funcs(templateFuncs(nil)).Parse(tpl)
+	if err != nil {
+		return nil, err
+	}
+	var used []string
+	use := func(name string) {
+		if !slices.Contains(used, name) {
+			used = append(used, name)
+		}
+	}
+	var walk func(n parse.Node)
+	walk = func(n parse.Node) {
+		switch n := n.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+			for _, c := range n.Nodes {
+				walk(c)
+			}
+		case *parse.ActionNode:
+			walk(n.Pipe)
+		case *parse.IfNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.RangeNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.WithNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.PipeNode:
+			if n == nil {
+				return
+			}
+			for _, c := range n.Cmds {
+				walk(c)
+			}
+		case *parse.CommandNode:
+			for i, arg := range n.Args {
+				if id, ok := arg.(*parse.IdentifierNode); ok && id.Ident == "get" && i+1 < len(n.Args) {
+					if s, ok := n.Args[i+1].(*parse.StringNode); ok {
+						use(s.Text)
+					}
+				}
+				walk(arg)
+			}
+		case *parse.FieldNode:
+			use(n.Ident[0])
+		case *parse.VariableNode:
+			if len(n.Ident) > 1 && n.Ident[0] == "$" {
+				use(n.Ident[1])
+			}
+		case *parse.ChainNode:
+			walk(n.Node)
+		}
+	}
+	walk(t.Tree.Root)
+	return used, nil
+}
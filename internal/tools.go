@@ -0,0 +1,197 @@
+package internal
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/aottr/sth/internal/platform"
+	"github.com/aottr/sth/internal/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// Tool is one logical tool of the tools: section, installed through the
+// first backend of Prefer that applies on this machine. Names maps backends
+// ("recipe", "apt", "brew", ...) or families ("debian", "rhel", ...) to the
+// package name there:
+//
+//	tools:
+//	  fd:
+//	    prefer: [recipe, native]
+//	    recipe: fd
+//	    debian: fd-find
+//	    brew: fd
+//
+// A tool without names uses its own name everywhere. Otherwise a backend
+// without a name is only used when prefer lists it, as the same name can be
+// a different package there.
+type Tool struct {
+	Prefer  []string          `yaml:"prefer,omitempty"`
+	Version string            `yaml:"version,omitempty"` // "latest" or a pin, where the backend supports it
+	Names   map[string]string `yaml:",inline"`
+}
+
+func (t *Tool) UnmarshalYAML(value *yaml.Node) error {
+	// `ripgrep: ~` and `ripgrep: {}` mean the same name everywhere
+	if value.Kind == yaml.ScalarNode {
+		return nil
+	}
+	type plain Tool
+	return value.Decode((*plain)(t))
+}
+
+// DefaultToolPreference is used for tools without prefer. "native" is the
+// package manager of the platform family.
+var DefaultToolPreference = []string{"native", "brew", "recipe", "flatpak", "snap", "pipx", "cargo", "go", "npm"}
+
+// nativeManagers are the native package managers sth has drivers for, by family
+var nativeManagers = map[string]string{
+	"debian": string(PackageTypeApt),
+}
+
+// backendCommands are the commands that must be on PATH for a backend to apply
+var backendCommands = map[string]string{
+	string(PackageTypeBrew):    "brew",
+	string(PackageTypeFlatpak): "flatpak",
+	string(PackageTypeSnap):    "snap",
+	string(PackageTypePipx):    "pipx",
+	string(PackageTypeCargo):   "cargo",
+	string(PackageTypeGo):      "go",
+	string(PackageTypeNpm):     "npm",
+}
+
+// resolveTools adds every tool to the section of the first backend that
+// applies, and records tools no backend applies to as skipped
+func (p *Packages) resolveTools(info platform.Info) error {
+	for _, name := range utils.SortedKeys(p.Tools) {
+		tool := p.Tools[name]
+		backend, pkg, ok := tool.choose(name, info)
+		if !ok {
+			p.Skipped = append(p.Skipped, Skipped{
+				Manager: "tools",
+				Name:    name,
+				Reason:  fmt.Sprintf("no backend of %s applies on %s/%s", strings.Join(tool.preference(), ", "), info.OS, info.Family),
+			})
+			continue
+		}
+		if err := p.Sections.addTool(backend, pkg, tool.Version); err != nil {
+			return fmt.Errorf("tool %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func (t Tool) preference() []string {
+	if len(t.Prefer) > 0 {
+		return t.Prefer
+	}
+	return DefaultToolPreference
+}
+
+// choose returns the section and package name of the first preferred
+// backend that applies and has a name for the tool, see Tool
+func (t Tool) choose(name string, info platform.Info) (string, string, bool) {
+	for _, backend := range t.preference() {
+		backend = strings.TrimSpace(backend)
+		keys := []string{backend}
+		switch backend {
+		case "native":
+			manager, ok := nativeManagers[info.Family]
+			if !ok {
+				continue
+			}
+			backend = manager
+			keys = []string{manager, info.Family}
+		case "recipe", string(PackageTypeRecipe):
+			backend = string(PackageTypeRecipe)
+			keys = []string{"recipe", string(PackageTypeRecipe)}
+		default:
+			if manager, ok := nativeManagers[backend]; ok {
+				// a family such as "debian" stands for its native manager
+				if backend != info.Family {
+					continue
+				}
+				keys = []string{manager, backend}
+				backend = manager
+			} else if isNative(backend) {
+				if nativeManagers[info.Family] != backend {
+					continue
+				}
+				keys = []string{backend, info.Family}
+			} else if cmd, ok := backendCommands[backend]; ok {
+				if _, err := exec.LookPath(cmd); err != nil {
+					continue
+				}
+			} else {
+				continue
+			}
+		}
+
+		for _, k := range keys {
+			if pkg := strings.TrimSpace(t.Names[k]); pkg != "" {
+				return backend, pkg, true
+			}
+		}
+		if len(t.Names) == 0 || len(t.Prefer) > 0 {
+			return backend, name, true
+		}
+	}
+	return "", "", false
+}
+
+func isNative(backend string) bool {
+	for _, m := range nativeManagers {
+		if m == backend {
+			return true
+		}
+	}
+	return false
+}
+
+// addTool declares pkg in the section of backend unless it is declared already
+func (s *Sections) addTool(backend, pkg, version string) error {
+	version = utils.WithDefault(version, "latest")
+	addVersion := func(m map[string]string) map[string]string {
+		if m == nil {
+			m = make(map[string]string)
+		}
+		if _, ok := m[pkg]; !ok {
+			m[pkg] = version
+		}
+		return m
+	}
+	switch PackageType(backend) {
+	case PackageTypeApt:
+		s.Apt = addVersion(s.Apt)
+	case PackageTypePipx:
+		s.Pipx = addVersion(s.Pipx)
+	case PackageTypeCargo:
+		s.Cargo = addVersion(s.Cargo)
+	case PackageTypeGo:
+		s.Go = addVersion(s.Go)
+	case PackageTypeNpm:
+		s.Npm = addVersion(s.Npm)
+	case PackageTypeBrew:
+		s.Brew.Formulae = appendMissing(s.Brew.Formulae, BrewFormula{Name: pkg}, func(f BrewFormula) string { return f.Name })
+	case PackageTypeFlatpak:
+		s.Flatpak = appendMissing(s.Flatpak, FlatpakRef{ID: pkg}, func(r FlatpakRef) string { return r.ID })
+	case PackageTypeSnap:
+		s.Snap = appendMissing(s.Snap, SnapPackage{Name: pkg}, func(p SnapPackage) string { return p.Name })
+	case PackageTypeRecipe:
+		s.Recipes = appendMissing(s.Recipes, pkg, func(r string) string { return r })
+	default:
+		return fmt.Errorf("unknown backend %q", backend)
+	}
+	return nil
+}
+
+// appendMissing appends item unless list has an item with the same key, so
+// explicit entries keep their options
+func appendMissing[T any](list []T, item T, key func(T) string) []T {
+	for _, existing := range list {
+		if key(existing) == key(item) {
+			return list
+		}
+	}
+	return append(list, item)
+}
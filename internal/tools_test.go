@@ -0,0 +1,41 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/aottr/sth/internal/platform"
+)
+
+func TestToolChoose(t *testing.T) {
+	// no brew, flatpak, ... on PATH, so only native and recipe apply
+	t.Setenv("PATH", "")
+	debian := platform.Info{OS: "linux", Family: "debian"}
+	fedora := platform.Info{OS: "linux", Family: "rhel"}
+	tests := []struct {
+		name    string
+		tool    Tool
+		info    platform.Info
+		backend string
+		pkg     string
+		ok      bool
+	}{
+		{"no names", Tool{}, debian, "apt", "fd", true},
+		{"family name", Tool{Names: map[string]string{"debian": "fd-find"}}, debian, "apt", "fd-find", true},
+		{"manager name", Tool{Names: map[string]string{"apt": "fd-find"}}, debian, "apt", "fd-find", true},
+		{"names for other backends only", Tool{Names: map[string]string{"recipe": "fd", "brew": "fd"}}, debian, "recipes", "fd", true},
+		{"recipes key", Tool{Names: map[string]string{"recipes": "fd-bin"}}, debian, "recipes", "fd-bin", true},
+		{"listed in prefer", Tool{Prefer: []string{"native"}, Names: map[string]string{"brew": "fd"}}, debian, "apt", "fd", true},
+		{"prefer order", Tool{Prefer: []string{"recipe", "native"}}, debian, "recipes", "fd", true},
+		{"family in prefer", Tool{Prefer: []string{"debian"}}, debian, "apt", "fd", true},
+		{"other family in prefer", Tool{Prefer: []string{"debian"}}, fedora, "", "", false},
+		{"no native manager", Tool{Prefer: []string{"native"}}, fedora, "", "", false},
+		{"backend not on PATH", Tool{Prefer: []string{"brew"}}, debian, "", "", false},
+		{"no backend has a name", Tool{Names: map[string]string{"brew": "fd"}}, debian, "", "", false},
+	}
+	for _, tt := range tests {
+		backend, pkg, ok := tt.tool.choose("fd", tt.info)
+		if backend != tt.backend || pkg != tt.pkg || ok != tt.ok {
+			t.Errorf("%s: choose = %q, %q, %v, want %q, %q, %v", tt.name, backend, pkg, ok, tt.backend, tt.pkg, tt.ok)
+		}
+	}
+}
@@ -1,5 +1,12 @@
 package internal
 
+import (
+	"fmt"
+	"regexp"
+
+	"gopkg.in/yaml.v3"
+)
+
 type Dependencies struct {
 	// Native, family-specific channels
 	Apt []string `yaml:"apt,omitempty"`
@@ -42,3 +49,186 @@ type RecipeIndexEntry struct {
 	Arch   string       `yaml:"arch,omitempty"`   // e.g., ["amd64","arm64"]
 	Deps   Dependencies `yaml:"deps,omitempty"`
 }
+
+// AptRepository describes a third-party apt source written as a deb822
+// .sources file with its own keyring under /etc/apt/keyrings.
+type AptRepository struct {
+	Name          string   `yaml:"name"`                    // file stem, e.g. "docker"
+	URIs          []string `yaml:"uris"`                    // e.g. ["https://download.docker.com/linux/ubuntu"]
+	Suites        []string `yaml:"suites"`                  // e.g. ["noble"] or ["{{.Codename}}"]
+	Components    []string `yaml:"components,omitempty"`    // e.g. ["stable"]
+	Architectures []string `yaml:"architectures,omitempty"` // e.g. ["amd64"]
+	KeyURL        string   `yaml:"keyUrl,omitempty"`        // armored or binary key to download
+	Key           string   `yaml:"key,omitempty"`           // inline armored key
+}
+
+// aptRepositoryName is the file stem of an apt repository's keyring and
+// .sources file
+var aptRepositoryName = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// CheckName fails unless the name can be used as a file stem under
+// /etc/apt, i.e. only letters, digits, '.', '_' and '-' and not "." or ".."
+func (r AptRepository) CheckName() error {
+	if !aptRepositoryName.MatchString(r.Name) || r.Name == "." || r.Name == ".." {
+		return fmt.Errorf("invalid apt repository name %q, use only letters, digits, '.', '_' and '-'", r.Name)
+	}
+	return nil
+}
+
+// FlatpakRemote is a flatpak remote added before installing refs
+type FlatpakRemote struct {
+	Name string `yaml:"name"`           // e.g. "flathub"
+	URL  string `yaml:"url"`            // e.g. "https://dl.flathub.org/repo/flathub.flatpakrepo"
+	User bool   `yaml:"user,omitempty"` // add to the per-user installation
+}
+
+// FlatpakRef is a flatpak application. In packages.yml it is either a plain
+// application ID or a mapping with the optional fields below.
+type FlatpakRef struct {
+	ID        string   `yaml:"id"`                  // e.g. "org.mozilla.firefox"
+	Remote    string   `yaml:"remote,omitempty"`    // default "flathub"
+	Branch    string   `yaml:"branch,omitempty"`    // e.g. "stable"
+	User      bool     `yaml:"user,omitempty"`      // --user instead of --system
+	Overrides []string `yaml:"overrides,omitempty"` // flatpak override flags, e.g. "--filesystem=home"
+}
+
+func (f *FlatpakRef) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		f.ID = value.Value
+		return nil
+	}
+	type plain FlatpakRef
+	return value.Decode((*plain)(f))
+}
+
+func (f FlatpakRef) MarshalYAML() (any, error) {
+	if f.Remote == "" && f.Branch == "" && !f.User && len(f.Overrides) == 0 {
+		return f.ID, nil
+	}
+	type plain FlatpakRef
+	return plain(f), nil
+}
+
+// FlatpakIDs returns the application IDs of refs
+func FlatpakIDs(refs []FlatpakRef) []string {
+	ids := make([]string, 0, len(refs))
+	for _, r := range refs {
+		ids = append(ids, r.ID)
+	}
+	return ids
+}
+
+// Brew is the brew section of packages.yml. A plain list is read as formulae.
+type Brew struct {
+	Taps     []BrewTap     `yaml:"taps,omitempty"`
+	Formulae []BrewFormula `yaml:"formulae,omitempty"`
+	Casks    []BrewCask    `yaml:"casks,omitempty"`
+}
+
+func (b *Brew) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.SequenceNode {
+		return value.Decode(&b.Formulae)
+	}
+	type plain Brew
+	return value.Decode((*plain)(b))
+}
+
+// IsEmpty reports whether nothing is declared
+func (b Brew) IsEmpty() bool {
+	return len(b.Taps) == 0 && len(b.Formulae) == 0 && len(b.Casks) == 0
+}
+
+// BrewTap is a third-party tap, either "user/repo" or a mapping with a URL
+type BrewTap struct {
+	Name string `yaml:"name"`          // e.g. "hashicorp/tap"
+	URL  string `yaml:"url,omitempty"` // clone URL for taps outside GitHub
+}
+
+func (t *BrewTap) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		t.Name = value.Value
+		return nil
+	}
+	type plain BrewTap
+	return value.Decode((*plain)(t))
+}
+
+func (t BrewTap) MarshalYAML() (any, error) {
+	if t.URL == "" {
+		return t.Name, nil
+	}
+	type plain BrewTap
+	return plain(t), nil
+}
+
+// BrewFormula is a formula name or a mapping with Brewfile options
+type BrewFormula struct {
+	Name string   `yaml:"name"`           // e.g. "wget" or "hashicorp/tap/terraform"
+	Args []string `yaml:"args,omitempty"` // e.g. ["HEAD"]
+	Link *bool    `yaml:"link,omitempty"` // false keeps keg-only formulae unlinked
+}
+
+func (f *BrewFormula) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		f.Name = value.Value
+		return nil
+	}
+	type plain BrewFormula
+	return value.Decode((*plain)(f))
+}
+
+func (f BrewFormula) MarshalYAML() (any, error) {
+	if len(f.Args) == 0 && f.Link == nil {
+		return f.Name, nil
+	}
+	type plain BrewFormula
+	return plain(f), nil
+}
+
+// BrewCask is a cask name or a mapping with Brewfile options
+type BrewCask struct {
+	Name string   `yaml:"name"`           // e.g. "visual-studio-code"
+	Args []string `yaml:"args,omitempty"` // e.g. ["no-quarantine"]
+}
+
+func (c *BrewCask) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		c.Name = value.Value
+		return nil
+	}
+	type plain BrewCask
+	return value.Decode((*plain)(c))
+}
+
+func (c BrewCask) MarshalYAML() (any, error) {
+	if len(c.Args) == 0 {
+		return c.Name, nil
+	}
+	type plain BrewCask
+	return plain(c), nil
+}
+
+// SnapPackage is a snap name or a mapping with install options
+type SnapPackage struct {
+	Name     string `yaml:"name"`               // e.g. "multipass"
+	Channel  string `yaml:"channel,omitempty"`  // e.g. "latest/stable"
+	Classic  bool   `yaml:"classic,omitempty"`  // install with --classic confinement
+	Revision string `yaml:"revision,omitempty"` // pin to a revision
+}
+
+func (s *SnapPackage) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		s.Name = value.Value
+		return nil
+	}
+	type plain SnapPackage
+	return value.Decode((*plain)(s))
+}
+
+func (s SnapPackage) MarshalYAML() (any, error) {
+	if s.Channel == "" && !s.Classic && s.Revision == "" {
+		return s.Name, nil
+	}
+	type plain SnapPackage
+	return plain(s), nil
+}
@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// WriteFileAtomic writes data to a temporary file next to path and renames
+// it over path, so readers never see a partially written file. An existing
+// file keeps its permissions.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	if st, err := os.Stat(path); err == nil {
+		perm = st.Mode().Perm()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LockTimeout is how long LockFile waits for another process
+var LockTimeout = 30 * time.Second
+
+// LockFile takes an exclusive lock on path through a "<path>.lock" file and
+// returns the function releasing it. A lock left behind by a process that
+// died is never broken automatically, as telling it from a slow process is
+// racy; the error names the file to remove.
+func LockFile(path string) (func(), error) {
+	lock := path + ".lock"
+	deadline := time.Now().Add(LockTimeout)
+	for {
+		f, err := os.OpenFile(lock, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			fmt.Fprintf(f, "%d\n", os.Getpid())
+			f.Close()
+			return func() { os.Remove(lock) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%s is locked by another sth process (remove %s if it is stale)", path, lock)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
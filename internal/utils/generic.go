@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -58,3 +59,29 @@ func CmpSemVer(a, b SemVer) int {
 	}
 	return 0
 }
+
+// VersionSatisfies reports whether an installed version fulfils a pin from
+// packages.yml. Empty, "latest" and "*" accept any installed version;
+// otherwise versions are compared exactly, ignoring a leading "v".
+func VersionSatisfies(installed, wanted string) bool {
+	wanted = strings.TrimSpace(wanted)
+	if wanted == "" || strings.EqualFold(wanted, "latest") || wanted == "*" {
+		return true
+	}
+	return strings.TrimPrefix(strings.TrimSpace(installed), "v") == strings.TrimPrefix(wanted, "v")
+}
+
+// IsPinned reports whether wanted names a concrete version
+func IsPinned(wanted string) bool {
+	return !VersionSatisfies("", wanted)
+}
+
+// SortedKeys returns the keys of m in ascending order
+func SortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
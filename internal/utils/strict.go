@@ -0,0 +1,150 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DecodeStrict decodes a YAML document into v and fails on keys v has no
+// field for, see CheckKnownFields
+func DecodeStrict(data []byte, v any) error {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return err
+	}
+	if root.Kind == 0 {
+		return nil
+	}
+	if err := CheckKnownFields(&root, v); err != nil {
+		return err
+	}
+	return root.Decode(v)
+}
+
+// CheckKnownFields reports every mapping key in node that has no field in
+// the type of v, with its line and column. It is yaml's KnownFields(true)
+// for nodes, and it also looks into types with their own UnmarshalYAML,
+// which decode a plain copy of themselves and so lose strictness.
+func CheckKnownFields(node *yaml.Node, v any) error {
+	var errs []error
+	checkKnown(node, reflect.TypeOf(v), "", &errs)
+	return errors.Join(errs...)
+}
+
+func checkKnown(node *yaml.Node, t reflect.Type, path string, errs *[]error) {
+	if node == nil || t == nil {
+		return
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if node.Kind == yaml.DocumentNode {
+		for _, c := range node.Content {
+			checkKnown(c, t, path, errs)
+		}
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if t == reflect.TypeOf(yaml.Node{}) {
+			return
+		}
+		if node.Kind == yaml.SequenceNode {
+			// scalar-or-list types such as a plain brew list; check the items
+			// against the first list field
+			for i := 0; i < t.NumField(); i++ {
+				if f := t.Field(i); f.IsExported() && f.Type.Kind() == reflect.Slice {
+					checkKnown(node, f.Type, path, errs)
+					return
+				}
+			}
+			return
+		}
+		if node.Kind != yaml.MappingNode {
+			return
+		}
+		fields, inline := yamlFields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if key.Value == "<<" {
+				continue
+			}
+			if ft, ok := fields[key.Value]; ok {
+				checkKnown(value, ft, joinPath(path, key.Value), errs)
+				continue
+			}
+			if inline != nil {
+				checkKnown(value, inline, joinPath(path, key.Value), errs)
+				continue
+			}
+			where := ""
+			if path != "" {
+				where = " in " + path
+			}
+			*errs = append(*errs, fmt.Errorf("line %d, column %d: unknown field %q%s", key.Line, key.Column, key.Value, where))
+		}
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			checkKnown(node.Content[i+1], t.Elem(), joinPath(path, node.Content[i].Value), errs)
+		}
+	case reflect.Slice, reflect.Array:
+		if node.Kind != yaml.SequenceNode {
+			return
+		}
+		for i, item := range node.Content {
+			checkKnown(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i), errs)
+		}
+	}
+}
+
+// yamlFields returns the field types of struct t by YAML key, and the value
+// type of an inline map if t has one
+func yamlFields(t reflect.Type) (map[string]reflect.Type, reflect.Type) {
+	fields := make(map[string]reflect.Type)
+	var inline reflect.Type
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if name == "-" {
+			continue
+		}
+		if strings.Contains(opts, "inline") {
+			switch f.Type.Kind() {
+			case reflect.Struct:
+				sub, subInline := yamlFields(f.Type)
+				for k, v := range sub {
+					fields[k] = v
+				}
+				if subInline != nil {
+					inline = subInline
+				}
+			case reflect.Map:
+				inline = f.Type.Elem()
+			}
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		fields[name] = f.Type
+	}
+	return fields, inline
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
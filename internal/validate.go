@@ -0,0 +1,123 @@
+package internal
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/aottr/sth/internal/platform"
+	"github.com/aottr/sth/internal/utils"
+)
+
+// packageTypes are the sections that declare packages
+var packageTypes = []string{
+	string(PackageTypeApt), string(PackageTypeFlatpak), string(PackageTypeBrew), string(PackageTypeSnap),
+	string(PackageTypePipx), string(PackageTypeCargo), string(PackageTypeGo), string(PackageTypeNpm),
+	string(PackageTypeRecipe),
+}
+
+// toolKeys are the backends and families a tool may prefer or name
+var toolKeys = append([]string{"native", "recipe", platform.FamilyDebian, platform.FamilyRHEL, platform.FamilyArch}, packageTypes...)
+
+// Validate checks what decoding cannot: duplicate and unnamed entries,
+// incomplete repositories and remotes, unknown tool backends and invalid
+// patterns. Every profile and host overlay is checked, selected or not.
+func (p *Packages) Validate() []error {
+	errs := p.Sections.validate("")
+	for _, name := range utils.SortedKeys(p.Profiles) {
+		errs = append(errs, p.Profiles[name].validate("profiles."+name+".")...)
+	}
+	for _, glob := range utils.SortedKeys(p.Hosts) {
+		if _, err := path.Match(glob, ""); err != nil {
+			errs = append(errs, fmt.Errorf("hosts.%s: invalid pattern: %w", glob, err))
+		}
+		errs = append(errs, p.Hosts[glob].validate("hosts."+glob+".")...)
+	}
+	return errs
+}
+
+func (s Sections) validate(prefix string) []error {
+	var errs []error
+	fail := func(field, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s%s: %s", prefix, field, fmt.Sprintf(format, args...)))
+	}
+	unique := func(section string, names []string) {
+		seen := make(map[string]bool, len(names))
+		for i, name := range names {
+			switch {
+			case strings.TrimSpace(name) == "":
+				fail(fmt.Sprintf("%s[%d]", section, i), "has no name")
+			case seen[name]:
+				fail(section, "%s is declared twice", name)
+			}
+			seen[name] = true
+		}
+	}
+
+	unique("flatpak", FlatpakIDs(s.Flatpak))
+	unique("snap", names(s.Snap, func(p SnapPackage) string { return p.Name }))
+	unique("recipes", s.Recipes)
+	unique("brew.taps", names(s.Brew.Taps, func(t BrewTap) string { return t.Name }))
+	unique("brew.formulae", names(s.Brew.Formulae, func(f BrewFormula) string { return f.Name }))
+	unique("brew.casks", names(s.Brew.Casks, func(c BrewCask) string { return c.Name }))
+	unique("aptRepositories", names(s.AptRepositories, func(r AptRepository) string { return r.Name }))
+	unique("flatpakRemotes", names(s.FlatpakRemotes, func(r FlatpakRemote) string { return r.Name }))
+
+	for _, repo := range s.AptRepositories {
+		field := "aptRepositories." + repo.Name
+		if err := repo.CheckName(); err != nil && strings.TrimSpace(repo.Name) != "" {
+			fail(field, "name is a file stem: %v", err)
+		}
+		if len(repo.URIs) == 0 {
+			fail(field, "uris is required")
+		}
+		if len(repo.Suites) == 0 {
+			fail(field, "suites is required")
+		}
+		if strings.TrimSpace(repo.Key) == "" && strings.TrimSpace(repo.KeyURL) == "" {
+			fail(field, "key or keyUrl is required")
+		}
+	}
+	for _, remote := range s.FlatpakRemotes {
+		if strings.TrimSpace(remote.URL) == "" {
+			fail("flatpakRemotes."+remote.Name, "url is required")
+		}
+	}
+
+	for _, name := range utils.SortedKeys(s.Tools) {
+		tool := s.Tools[name]
+		for _, backend := range tool.Prefer {
+			if !slices.Contains(toolKeys, strings.TrimSpace(backend)) {
+				fail("tools."+name+".prefer", "unknown backend %q", backend)
+			}
+		}
+		for _, key := range utils.SortedKeys(tool.Names) {
+			if !slices.Contains(toolKeys, key) || key == "native" {
+				fail("tools."+name, "unknown field %q, names are keyed by backend or family", key)
+			}
+		}
+	}
+
+	for _, pattern := range s.Protected {
+		glob := pattern
+		if manager, rest, ok := strings.Cut(pattern, ":"); ok {
+			if !slices.Contains(packageTypes, manager) {
+				fail("protected", "unknown manager %q in %q", manager, pattern)
+			}
+			glob = rest
+		}
+		if _, err := path.Match(glob, ""); err != nil {
+			fail("protected", "invalid pattern %q: %v", pattern, err)
+		}
+	}
+	return errs
+}
+
+func names[T any](list []T, name func(T) string) []string {
+	out := make([]string, 0, len(list))
+	for _, item := range list {
+		out = append(out, name(item))
+	}
+	return out
+}
@@ -0,0 +1,207 @@
+package internal
+
+import (
+	"fmt"
+
+	"github.com/aottr/sth/internal/platform"
+	"github.com/aottr/sth/internal/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// Skipped is an entry left out on this machine, because its `when:`
+// condition does not hold or, for tools, no backend applies
+type Skipped struct {
+	// Scope is "" for the base sections, or the profile or host overlay
+	// the entry belongs to, e.g. "profile backend"
+	Scope   string `json:"scope,omitempty"`
+	Manager string `json:"manager"`
+	Name    string `json:"name"`
+	// When is the condition that does not hold, empty for other skips
+	When string `json:"when,omitempty"`
+	// Reason explains the skip for plans and status reports
+	Reason string `json:"reason"`
+}
+
+// mapSections are name -> version mappings whose values may be a mapping
+// with version and when
+var mapSections = map[string]bool{"apt": true, "pipx": true, "cargo": true, "go": true, "npm": true}
+
+// listSections are sequences of entries and the key holding an entry's name
+var listSections = map[string]string{
+	"flatpak":         "id",
+	"snap":            "name",
+	"recipes":         "name",
+	"aptRepositories": "name",
+	"flatpakRemotes":  "name",
+}
+
+// decodePackages parses packages.yml data into p, leaving out entries whose
+// when: condition does not hold for info, and returns those entries
+func decodePackages(data []byte, p *Packages, info platform.Info) ([]Skipped, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("failed to parse YAML: %v", err)
+	}
+	return decodeNode(&root, p, info)
+}
+
+// decodeNode is decodePackages for a parsed document. It edits root.
+func decodeNode(root *yaml.Node, p *Packages, info platform.Info) ([]Skipped, error) {
+	if root.Kind == 0 {
+		return nil, nil
+	}
+	skipped, err := filterWhen(root, info)
+	if err != nil {
+		return nil, err
+	}
+	if err := utils.CheckKnownFields(root, p); err != nil {
+		return nil, err
+	}
+	if err := root.Decode(p); err != nil {
+		return nil, fmt.Errorf("failed to parse YAML: %v", err)
+	}
+	return skipped, nil
+}
+
+// filterWhen applies the when: conditions of the base sections and of every
+// profile and host overlay
+func filterWhen(root *yaml.Node, info platform.Info) ([]Skipped, error) {
+	top := root
+	if top.Kind == yaml.DocumentNode && len(top.Content) > 0 {
+		top = top.Content[0]
+	}
+	skipped, err := filterSections(top, "", info)
+	if err != nil {
+		return nil, err
+	}
+	for _, group := range []struct{ key, scope string }{{"profiles", "profile "}, {"hosts", "host "}} {
+		overlays := mappingValue(top, group.key)
+		if overlays == nil || overlays.Kind != yaml.MappingNode {
+			continue
+		}
+		for i := 0; i+1 < len(overlays.Content); i += 2 {
+			s, err := filterSections(overlays.Content[i+1], group.scope+overlays.Content[i].Value, info)
+			if err != nil {
+				return nil, err
+			}
+			skipped = append(skipped, s...)
+		}
+	}
+	return skipped, nil
+}
+
+func filterSections(m *yaml.Node, scope string, info platform.Info) ([]Skipped, error) {
+	if m == nil || m.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	var skipped []Skipped
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		key, section := m.Content[i].Value, m.Content[i+1]
+		var (
+			s   []Skipped
+			err error
+		)
+		switch {
+		case mapSections[key]:
+			s, err = filterMap(section, key, scope, info)
+		case listSections[key] != "":
+			s, err = filterList(section, key, listSections[key], scope, info)
+		case key == "brew" && section.Kind == yaml.SequenceNode:
+			s, err = filterList(section, key, "name", scope, info)
+		case key == "brew":
+			for _, sub := range []string{"taps", "formulae", "casks"} {
+				var subSkipped []Skipped
+				subSkipped, err = filterList(mappingValue(section, sub), key, "name", scope, info)
+				if err != nil {
+					break
+				}
+				s = append(s, subSkipped...)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		skipped = append(skipped, s...)
+	}
+	return skipped, nil
+}
+
+// filterMap handles `name: {version: ..., when: ...}` values, which are
+// replaced by their version
+func filterMap(section *yaml.Node, manager, scope string, info platform.Info) ([]Skipped, error) {
+	if section == nil || section.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	var skipped []Skipped
+	var kept []*yaml.Node
+	for i := 0; i+1 < len(section.Content); i += 2 {
+		k, v := section.Content[i], section.Content[i+1]
+		if v.Kind == yaml.MappingNode {
+			for j := 0; j+1 < len(v.Content); j += 2 {
+				if key := v.Content[j]; key.Value != "version" && key.Value != "when" {
+					return nil, fmt.Errorf("line %d, column %d: unknown field %q in %s.%s", key.Line, key.Column, key.Value, manager, k.Value)
+				}
+			}
+			ok, when, err := evalWhen(v, info)
+			if err != nil {
+				return nil, fmt.Errorf("%s %s: %w", manager, k.Value, err)
+			}
+			if !ok {
+				skipped = append(skipped, Skipped{Scope: scope, Manager: manager, Name: k.Value, When: when, Reason: "when: " + when})
+				continue
+			}
+			version := "latest"
+			if vv := mappingValue(v, "version"); vv != nil {
+				version = vv.Value
+			}
+			v = scalar(version)
+		}
+		kept = append(kept, k, v)
+	}
+	section.Content = kept
+	return skipped, nil
+}
+
+// filterList handles sequence entries that are mappings with a when key.
+// Entries left with only their name become plain scalars again.
+func filterList(section *yaml.Node, manager, nameKey, scope string, info platform.Info) ([]Skipped, error) {
+	if section == nil || section.Kind != yaml.SequenceNode {
+		return nil, nil
+	}
+	var skipped []Skipped
+	var kept []*yaml.Node
+	for _, item := range section.Content {
+		if item.Kind == yaml.MappingNode && mappingValue(item, "when") != nil {
+			name := entryName(item, nameKey)
+			ok, when, err := evalWhen(item, info)
+			if err != nil {
+				return nil, fmt.Errorf("%s %s: %w", manager, name, err)
+			}
+			if !ok {
+				skipped = append(skipped, Skipped{Scope: scope, Manager: manager, Name: name, When: when, Reason: "when: " + when})
+				continue
+			}
+			if len(item.Content) == 2 && item.Content[0].Value == nameKey {
+				item = scalar(item.Content[1].Value)
+			}
+		}
+		kept = append(kept, item)
+	}
+	section.Content = kept
+	return skipped, nil
+}
+
+// evalWhen evaluates and removes the when key of an entry mapping. Entries
+// without a condition always apply.
+func evalWhen(entry *yaml.Node, info platform.Info) (bool, string, error) {
+	for i := 0; i+1 < len(entry.Content); i += 2 {
+		if entry.Content[i].Value != "when" {
+			continue
+		}
+		when := entry.Content[i+1].Value
+		entry.Content = append(entry.Content[:i:i], entry.Content[i+2:]...)
+		ok, err := platform.Eval(when, info)
+		return ok, when, err
+	}
+	return true, "", nil
+}
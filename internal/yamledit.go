@@ -0,0 +1,417 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// document is packages.yml as a yaml.v3 node tree. Edits go through the
+// nodes and encode splices only the touched items back into the original
+// lines, so comments, blank lines and formatting of everything else survive
+// a rewrite.
+type document struct {
+	root   yaml.Node
+	indent int
+
+	// lines of the file as read, each with its newline
+	lines []string
+	// touched holds the original items of the collections edited since
+	touched map[*yaml.Node][]*yaml.Node
+}
+
+func readDocument(path string) (*document, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	d, err := parseDocument(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return d, nil
+}
+
+func parseDocument(data []byte) (*document, error) {
+	d := &document{indent: detectIndent(data), touched: make(map[*yaml.Node][]*yaml.Node)}
+	if err := yaml.Unmarshal(data, &d.root); err != nil {
+		return nil, fmt.Errorf("failed to parse YAML: %v", err)
+	}
+	if d.root.Kind == 0 {
+		d.root = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	if len(d.root.Content) == 0 || d.root.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("top level must be a mapping")
+	}
+	d.lines = strings.SplitAfter(string(data), "\n")
+	if last := len(d.lines) - 1; d.lines[last] == "" {
+		d.lines = d.lines[:last]
+	} else {
+		d.lines[last] += "\n"
+	}
+	return d, nil
+}
+
+// update sets the top-level keys to those of v encoded. Keys whose value
+// is unchanged keep their lines, new keys are added at the end.
+func (d *document) update(v any) error {
+	var n yaml.Node
+	if err := n.Encode(v); err != nil {
+		return fmt.Errorf("failed to marshal YAML: %v", err)
+	}
+	top := d.root.Content[0]
+	d.touch(top)
+	var content []*yaml.Node
+	for i := 0; i+1 < len(top.Content); i += 2 {
+		if val := mappingValue(&n, top.Content[i].Value); val != nil {
+			if !sameValue(top.Content[i+1], val) {
+				top.Content[i+1] = val
+			}
+			content = append(content, top.Content[i], top.Content[i+1])
+		}
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if mappingValue(top, n.Content[i].Value) == nil {
+			content = append(content, n.Content[i], n.Content[i+1])
+		}
+	}
+	top.Content = content
+	return nil
+}
+
+// sameValue reports whether two nodes decode to the same data
+func sameValue(a, b *yaml.Node) bool {
+	var va, vb any
+	if a.Decode(&va) != nil || b.Decode(&vb) != nil {
+		return false
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+// section returns the value of a top-level key, or nil
+func (d *document) section(key string) *yaml.Node {
+	return mappingValue(d.root.Content[0], key)
+}
+
+// touch records the items of n before an edit, so that encode can tell
+// kept, removed and added items apart
+func (d *document) touch(n *yaml.Node) {
+	if _, ok := d.touched[n]; !ok {
+		d.touched[n] = slices.Clone(n.Content)
+	}
+}
+
+// changed reports whether n or anything below it was added or edited
+func (d *document) changed(n *yaml.Node) bool {
+	if n.Line == 0 {
+		return true
+	}
+	if _, ok := d.touched[n]; ok {
+		return true
+	}
+	return slices.ContainsFunc(n.Content, d.changed)
+}
+
+// encode writes the document. Unchanged items keep their original lines;
+// flow collections and new documents are encoded in full.
+func (d *document) encode() ([]byte, error) {
+	top := d.root.Content[0]
+	if top.Line == 0 || top.Style&yaml.FlowStyle != 0 {
+		return d.encodeNode(&d.root, 0)
+	}
+	var out []string
+	if err := d.splice(&out, top, 0, len(d.lines)); err != nil {
+		return nil, err
+	}
+	return []byte(strings.Join(out, "")), nil
+}
+
+// splice appends the lines [start, end) holding the block collection n to
+// out: unchanged items verbatim, edited ones re-encoded, removed ones
+// dropped together with their head comment. New items go after the last
+// original one.
+func (d *document) splice(out *[]string, n *yaml.Node, start, end int) error {
+	step := 1
+	if n.Kind == yaml.MappingNode {
+		step = 2
+	}
+	orig := n.Content
+	if o, ok := d.touched[n]; ok {
+		orig = o
+	}
+	indent := n.Column - 1
+
+	pos, first, dropped := start, len(*out), false
+	for i := 0; i+step <= len(orig); i += step {
+		from := orig[i].Line - 1
+		to := end
+		if i+step < len(orig) {
+			to = orig[i+step].Line - 1
+		}
+		// blank lines and comments up to the item's indentation belong to
+		// the gap before the next item
+		for to > from+1 && isGap(d.lines[to-1], indent) {
+			to--
+		}
+
+		gap := d.lines[pos:from]
+		if dropped && (len(*out) == first || isBlank((*out)[len(*out)-1])) {
+			// no blank line where a removed item was
+			for len(gap) > 0 && isBlank(gap[0]) {
+				gap = gap[1:]
+			}
+		}
+		mark := len(*out)
+		*out = append(*out, gap...)
+		pos = to
+
+		cur := slices.Index(n.Content, orig[i])
+		dropped = cur == -1
+		if dropped {
+			// the comment above the first key is the file's header
+			for len(*out) > mark && isComment((*out)[len(*out)-1]) && (start > 0 || i > 0) {
+				*out = (*out)[:len(*out)-1]
+			}
+			continue
+		}
+		item := n.Content[cur : cur+step]
+		switch {
+		case !slices.ContainsFunc(item, d.changed):
+			*out = append(*out, d.lines[from:to]...)
+		case step == 2 && !d.changed(item[0]) && isBlock(item[1]) && item[1].Line > item[0].Line:
+			*out = append(*out, d.lines[from:item[0].Line]...)
+			if err := d.splice(out, item[1], item[0].Line, to); err != nil {
+				return err
+			}
+		default:
+			if err := d.render(out, item, indent); err != nil {
+				return err
+			}
+		}
+	}
+
+	for i := 0; i+step <= len(n.Content); i += step {
+		if !slices.Contains(orig, n.Content[i]) {
+			if err := d.render(out, n.Content[i:i+step], indent); err != nil {
+				return err
+			}
+		}
+	}
+	*out = append(*out, d.lines[pos:end]...)
+	return nil
+}
+
+// render appends a mapping pair or sequence item encoded at indent. The
+// comments around an existing item stay in the original lines.
+func (d *document) render(out *[]string, item []*yaml.Node, indent int) error {
+	item = slices.Clone(item)
+	first := *item[0]
+	if first.Line != 0 {
+		first.HeadComment = ""
+		first.FootComment = ""
+	}
+	item[0] = &first
+	n := &yaml.Node{Kind: yaml.SequenceNode, Content: item}
+	if len(item) == 2 {
+		n.Kind = yaml.MappingNode
+	}
+	data, err := d.encodeNode(n, indent)
+	if err != nil {
+		return err
+	}
+	*out = append(*out, strings.SplitAfter(strings.TrimSuffix(string(data), "\n"), "\n")...)
+	(*out)[len(*out)-1] += "\n"
+	return nil
+}
+
+// encodeNode encodes n with the document's indentation, every line
+// shifted right by indent spaces
+func (d *document) encodeNode(n *yaml.Node, indent int) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(d.indent)
+	if err := enc.Encode(n); err != nil {
+		return nil, fmt.Errorf("failed to marshal YAML: %v", err)
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	if indent == 0 {
+		return buf.Bytes(), nil
+	}
+	pad := strings.Repeat(" ", indent)
+	lines := strings.SplitAfter(buf.String(), "\n")
+	for i, l := range lines {
+		if strings.TrimSpace(l) != "" {
+			lines[i] = pad + l
+		}
+	}
+	return []byte(strings.Join(lines, "")), nil
+}
+
+// isGap reports whether line is blank or a comment indented at most indent
+func isGap(line string, indent int) bool {
+	trimmed := strings.TrimLeft(line, " \t")
+	if isBlank(trimmed) {
+		return true
+	}
+	return strings.HasPrefix(trimmed, "#") && len(line)-len(trimmed) <= indent
+}
+
+func isBlank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+func isComment(line string) bool {
+	return strings.HasPrefix(strings.TrimSpace(line), "#")
+}
+
+func isBlock(n *yaml.Node) bool {
+	return (n.Kind == yaml.MappingNode || n.Kind == yaml.SequenceNode) && n.Style&yaml.FlowStyle == 0 && len(n.Content) > 0
+}
+
+// detectIndent returns the indentation of the first nested line, falling
+// back to the 4 spaces yaml.Marshal uses
+func detectIndent(data []byte) int {
+	for _, line := range strings.Split(string(data), "\n") {
+		trimmed := strings.TrimLeft(line, " ")
+		if trimmed == "" || trimmed == line || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if n := len(line) - len(trimmed); n >= 2 {
+			return n
+		}
+	}
+	return 4
+}
+
+func mappingValue(m *yaml.Node, key string) *yaml.Node {
+	if m == nil || m.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// entryName is the name of a sequence entry: the scalar itself, or the
+// value of nameKey when the entry is a mapping
+func entryName(n *yaml.Node, nameKey string) string {
+	switch n.Kind {
+	case yaml.ScalarNode:
+		return n.Value
+	case yaml.MappingNode:
+		if v := mappingValue(n, nameKey); v != nil {
+			return v.Value
+		}
+	}
+	return ""
+}
+
+// removeEntries deletes the named entries from a mapping (by key) or a
+// sequence (by entryName) and returns the names it removed
+func (d *document) removeEntries(section *yaml.Node, names map[string]struct{}, nameKey string) []string {
+	if section == nil {
+		return nil
+	}
+	var removed []string
+	var kept []*yaml.Node
+	switch section.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(section.Content); i += 2 {
+			k := section.Content[i]
+			if _, ok := names[k.Value]; ok {
+				removed = append(removed, k.Value)
+				continue
+			}
+			kept = append(kept, k, section.Content[i+1])
+		}
+	case yaml.SequenceNode:
+		for _, item := range section.Content {
+			name := entryName(item, nameKey)
+			if _, ok := names[name]; ok && name != "" {
+				removed = append(removed, name)
+				continue
+			}
+			kept = append(kept, item)
+		}
+	default:
+		return nil
+	}
+	if len(removed) > 0 {
+		d.touch(section)
+		section.Content = kept
+	}
+	return removed
+}
+
+// addEntry declares name in the section of t unless it is already there,
+// creating the section when needed, and reports whether it changed anything
+func (d *document) addEntry(t PackageType, name string) bool {
+	switch t {
+	case PackageTypeApt, PackageTypePipx, PackageTypeCargo, PackageTypeGo, PackageTypeNpm:
+		m := d.ensureKey(d.root.Content[0], string(t), yaml.MappingNode)
+		if mappingValue(m, name) != nil {
+			return false
+		}
+		d.touch(m)
+		m.Content = append(m.Content, scalar(name), scalar("latest"))
+		return true
+	case PackageTypeBrew:
+		section := d.ensureKey(d.root.Content[0], string(t), yaml.MappingNode)
+		if section.Kind == yaml.MappingNode {
+			section = d.ensureKey(section, "formulae", yaml.SequenceNode)
+		}
+		return d.appendEntry(section, name, "name")
+	case PackageTypeFlatpak:
+		return d.appendEntry(d.ensureKey(d.root.Content[0], string(t), yaml.SequenceNode), name, "id")
+	default:
+		return d.appendEntry(d.ensureKey(d.root.Content[0], string(t), yaml.SequenceNode), name, "name")
+	}
+}
+
+// ensureKey returns the value of key in m, adding an empty node of kind when
+// the key is missing or null. An existing value of another kind is returned
+// as it is.
+func (d *document) ensureKey(m *yaml.Node, key string, kind yaml.Kind) *yaml.Node {
+	v := mappingValue(m, key)
+	if v != nil && v.Tag != "!!null" {
+		return v
+	}
+	node := &yaml.Node{Kind: kind}
+	if v != nil {
+		*v = *node
+		return v
+	}
+	d.touch(m)
+	m.Content = append(m.Content, scalar(key), node)
+	return node
+}
+
+// appendEntry appends name to a sequence unless an entry has that name
+func (d *document) appendEntry(seq *yaml.Node, name, nameKey string) bool {
+	if seq.Kind != yaml.SequenceNode {
+		return false
+	}
+	for _, item := range seq.Content {
+		if entryName(item, nameKey) == name {
+			return false
+		}
+	}
+	// flow sequences such as `casks: [a, b]` stay on one line
+	d.touch(seq)
+	seq.Content = append(seq.Content, scalar(name))
+	return true
+}
+
+func scalar(v string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Value: v}
+}
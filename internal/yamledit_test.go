@@ -0,0 +1,281 @@
+package internal
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+const editSource = `# shared packages
+
+name: team   # the name
+
+# from apt
+apt:
+  git: latest   # vcs
+
+  # build tools
+  make: latest
+  curl: latest
+  # more later
+
+recipes:
+  - fd
+brew:
+  formulae:
+    - name: jq
+
+  casks: [firefox, slack]
+flatpak:
+`
+
+func TestDocumentEdit(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(d *document)
+		want string
+	}{
+		{
+			name: "add to mapping",
+			edit: func(d *document) { d.addEntry(PackageTypeApt, "htop") },
+			want: `# shared packages
+
+name: team   # the name
+
+# from apt
+apt:
+  git: latest   # vcs
+
+  # build tools
+  make: latest
+  curl: latest
+  htop: latest
+  # more later
+
+recipes:
+  - fd
+brew:
+  formulae:
+    - name: jq
+
+  casks: [firefox, slack]
+flatpak:
+`,
+		},
+		{
+			name: "add to nested sequence",
+			edit: func(d *document) { d.addEntry(PackageTypeBrew, "ripgrep") },
+			want: `# shared packages
+
+name: team   # the name
+
+# from apt
+apt:
+  git: latest   # vcs
+
+  # build tools
+  make: latest
+  curl: latest
+  # more later
+
+recipes:
+  - fd
+brew:
+  formulae:
+    - name: jq
+    - ripgrep
+
+  casks: [firefox, slack]
+flatpak:
+`,
+		},
+		{
+			name: "fill null section and add a new one",
+			edit: func(d *document) {
+				d.addEntry(PackageTypeFlatpak, "org.gimp.GIMP")
+				d.addEntry(PackageTypeSnap, "lxd")
+			},
+			want: `# shared packages
+
+name: team   # the name
+
+# from apt
+apt:
+  git: latest   # vcs
+
+  # build tools
+  make: latest
+  curl: latest
+  # more later
+
+recipes:
+  - fd
+brew:
+  formulae:
+    - name: jq
+
+  casks: [firefox, slack]
+flatpak:
+  - org.gimp.GIMP
+snap:
+  - lxd
+`,
+		},
+		{
+			name: "add existing",
+			edit: func(d *document) { d.addEntry(PackageTypeRecipe, "fd") },
+			want: editSource,
+		},
+		{
+			name: "remove first item and the blank line after it",
+			edit: func(d *document) {
+				d.removeEntries(d.section("apt"), map[string]struct{}{"git": {}}, "name")
+			},
+			want: `# shared packages
+
+name: team   # the name
+
+# from apt
+apt:
+  # build tools
+  make: latest
+  curl: latest
+  # more later
+
+recipes:
+  - fd
+brew:
+  formulae:
+    - name: jq
+
+  casks: [firefox, slack]
+flatpak:
+`,
+		},
+		{
+			name: "remove item with its head comment",
+			edit: func(d *document) {
+				d.removeEntries(d.section("apt"), map[string]struct{}{"make": {}}, "name")
+			},
+			want: `# shared packages
+
+name: team   # the name
+
+# from apt
+apt:
+  git: latest   # vcs
+
+  curl: latest
+  # more later
+
+recipes:
+  - fd
+brew:
+  formulae:
+    - name: jq
+
+  casks: [firefox, slack]
+flatpak:
+`,
+		},
+		{
+			name: "remove from flow sequence",
+			edit: func(d *document) {
+				d.removeEntries(mappingValue(d.section("brew"), "casks"), map[string]struct{}{"slack": {}}, "name")
+			},
+			want: `# shared packages
+
+name: team   # the name
+
+# from apt
+apt:
+  git: latest   # vcs
+
+  # build tools
+  make: latest
+  curl: latest
+  # more later
+
+recipes:
+  - fd
+brew:
+  formulae:
+    - name: jq
+
+  casks: [firefox]
+flatpak:
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := parseDocument([]byte(editSource))
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.edit(d)
+			got, err := d.encode()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+			var check yaml.Node
+			if err := yaml.Unmarshal(got, &check); err != nil {
+				t.Errorf("result does not parse: %v", err)
+			}
+		})
+	}
+}
+
+func TestDocumentUpdate(t *testing.T) {
+	src := `# generated, then edited by hand
+name: laptop
+
+apt:
+  git: latest   # vcs
+recipes:
+  - fd
+`
+	d, err := parseDocument([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = d.update(Sections{
+		Apt:  map[string]string{"git": "latest"},
+		Pipx: map[string]string{"httpie": "latest"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := d.encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `# generated, then edited by hand
+
+apt:
+  git: latest   # vcs
+pipx:
+  httpie: latest
+`
+	if string(got) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestDocumentNew(t *testing.T) {
+	d, err := parseDocument(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.addEntry(PackageTypeApt, "git")
+	got, err := d.encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "apt:\n    git: latest\n"; string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}